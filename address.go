@@ -9,6 +9,12 @@ import (
 type Addr struct {
 	net.Addr
 	seq uint16
+	gen byte
+	// service is the server a client conn talks to, or the one a server
+	// conn is.
+	service ServiceID
+	// plain marks a stock QUIC peer, which is answered without a kuic header.
+	plain bool
 }
 
 func NewAddr(addr net.Addr, seq uint16) *Addr {
@@ -19,8 +25,15 @@ func (a *Addr) Network() string {
 }
 func (a *Addr) String() string {
 	addr := a.Addr.String()
+	if a.plain {
+		return addr
+	}
+	suffix := strconv.Itoa(int(a.seq))
+	if a.service != DefaultService {
+		suffix += "." + strconv.Itoa(int(a.service))
+	}
 	if strings.Contains(addr, "]") {
-		return strings.ReplaceAll(addr, "]", "%"+strconv.Itoa(int(a.seq))+"]")
+		return strings.ReplaceAll(addr, "]", "%"+suffix+"]")
 	}
-	return addr + "_" + strconv.Itoa(int(a.seq))
+	return addr + "_" + suffix
 }
@@ -0,0 +1,149 @@
+package kuic
+
+import (
+	"crypto/tls"
+	"github.com/quic-go/quic-go"
+	"sync"
+)
+
+// acceptQueueSize matches the accept queue of quic-go. While handlers are
+// registered, connections beyond it are refused rather than holding up the
+// handled protocols.
+const acceptQueueSize = 32
+
+const errorAcceptQueueFull quic.ApplicationErrorCode = 1
+
+// protocols dispatches the connections a listener accepts by their
+// negotiated ALPN: those with a handler go to it, the rest to Accept.
+type protocols struct {
+	handlers map[string]func(quic.Connection)
+	order    []string
+	accepted chan quic.Connection
+	done     chan struct{}
+	err      error
+	locker   *sync.RWMutex
+}
+
+func newProtocols() *protocols {
+	return &protocols{handlers: make(map[string]func(quic.Connection)), accepted: make(chan quic.Connection, acceptQueueSize), done: make(chan struct{}), locker: new(sync.RWMutex)}
+}
+
+func (p *protocols) handle(proto string, handler func(quic.Connection)) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	if handler == nil {
+		delete(p.handlers, proto)
+		for i, o := range p.order {
+			if o == proto {
+				p.order = append(p.order[:i:i], p.order[i+1:]...)
+				break
+			}
+		}
+		return
+	}
+	if _, ok := p.handlers[proto]; !ok {
+		p.order = append(p.order, proto)
+	}
+	p.handlers[proto] = handler
+}
+
+func (p *protocols) handler(proto string) func(quic.Connection) {
+	p.locker.RLock()
+	defer p.locker.RUnlock()
+	return p.handlers[proto]
+}
+
+func (p *protocols) handled() bool {
+	p.locker.RLock()
+	defer p.locker.RUnlock()
+	return len(p.handlers) > 0
+}
+
+// nextProtos appends the protocols with a handler to base, which keeps the
+// server's preference for its own protocols.
+func (p *protocols) nextProtos(base []string) []string {
+	p.locker.RLock()
+	defer p.locker.RUnlock()
+	protos := append([]string(nil), base...)
+	for _, proto := range p.order {
+		if !containsProto(protos, proto) {
+			protos = append(protos, proto)
+		}
+	}
+	return protos
+}
+
+func containsProto(protos []string, proto string) bool {
+	for _, p := range protos {
+		if p == proto {
+			return true
+		}
+	}
+	return false
+}
+
+// tlsConfig advertises the protocols registered at the time of each
+// handshake, the way http3.ConfigureTLSConfig picks its ALPN.
+func (p *protocols) tlsConfig(base *tls.Config) *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			conf := base
+			if base.GetConfigForClient != nil {
+				c, err := base.GetConfigForClient(hello)
+				if err != nil {
+					return nil, err
+				}
+				if c != nil {
+					conf = c
+				}
+			}
+			conf = conf.Clone()
+			conf.NextProtos = p.nextProtos(conf.NextProtos)
+			return conf, nil
+		},
+	}
+}
+
+func (bs *baseServer) dispatch() {
+	defer close(bs.protocols.done)
+	for {
+		conn, err := bs.listener.Accept(bs.context)
+		if err != nil {
+			bs.protocols.err = err
+			return
+		}
+		if handler := bs.protocols.handler(conn.ConnectionState().TLS.NegotiatedProtocol); handler != nil {
+			go handler(conn)
+			continue
+		}
+		select {
+		case bs.protocols.accepted <- conn:
+			continue
+		default:
+		}
+		if bs.protocols.handled() {
+			conn.CloseWithError(errorAcceptQueueFull, "accept queue full")
+			continue
+		}
+		// with nothing else to serve, wait for Accept and leave quic-go to hold
+		// back new handshakes meanwhile
+		select {
+		case bs.protocols.accepted <- conn:
+		case <-bs.context.Done():
+			conn.CloseWithError(0, "")
+		}
+	}
+}
+
+// Handle passes the accepted connections that negotiated proto to handler,
+// instead of queueing them for Accept, and advertises proto to clients from
+// then on. A nil handler removes proto again.
+//
+// Up to 32 connections wait for Accept. While no handler is registered,
+// further ones wait in quic-go, which refuses new handshakes once 32 of them
+// are waiting. While one is, a connection that finds the queue full is
+// closed with application error 1, so that an Accept that falls behind never
+// holds up the handled protocols.
+func (l *Listener) Handle(proto string, handler func(conn quic.Connection)) {
+	l.baseServer.protocols.handle(proto, handler)
+}
@@ -0,0 +1,109 @@
+package kuic
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHandleProtocol(t *testing.T) {
+	server, err := ListenConfig(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, &Config{Interop: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	h3 := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{http3.NextProtoH3}}
+	if _, err := quic.DialAddr(ctx, server.Addr().String(), h3, &quic.Config{HandshakeIdleTimeout: 300 * time.Millisecond}); err == nil {
+		t.Fatal("h3 negotiated without a handler")
+	}
+
+	web := &http3.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "web")
+	})}
+	server.Handle(http3.NextProtoH3, func(conn quic.Connection) {
+		web.ServeQUICConn(conn)
+	})
+	accepted := make(chan Connection, 2)
+	go func() {
+		for {
+			conn, err := server.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+			go func() {
+				stream, err := conn.AcceptStream(context.Background())
+				if err != nil {
+					return
+				}
+				io.Copy(stream, stream)
+				stream.Close()
+			}()
+		}
+	}()
+
+	roundTripper := &http3.RoundTripper{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	defer roundTripper.Close()
+	response, err := (&http.Client{Transport: roundTripper, Timeout: 5 * time.Second}).Get("https://" + server.Addr().String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "web" {
+		t.Fatalf("unexpected body %q", body)
+	}
+
+	conn, err := listenLocal(t).Dial(server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := echo(conn); err != nil {
+		t.Fatal(err)
+	}
+	if len(accepted) != 1 {
+		t.Fatalf("%d connections accepted, expected only the kuic one", len(accepted))
+	}
+	if protocol := (<-accepted).ConnectionState().NegotiatedProtocol; protocol != NextProto {
+		t.Fatalf("accepted a %q connection", protocol)
+	}
+}
+
+func TestAcceptBackpressure(t *testing.T) {
+	server := listenLocal(t)
+	client := listenLocal(t)
+	var dialed []Connection
+	for i := 0; i < acceptQueueSize+8; i++ {
+		conn, err := client.Dial(server.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		dialed = append(dialed, conn)
+	}
+	time.Sleep(200 * time.Millisecond)
+	for i, conn := range dialed {
+		if err := conn.(*connection).connection.Context().Err(); err != nil {
+			t.Fatalf("connection %d closed before Accept: %v", i, context.Cause(conn.(*connection).connection.Context()))
+		}
+	}
+	for range dialed {
+		conn, err := server.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	}
+}
@@ -4,81 +4,190 @@ import (
 	"context"
 	"errors"
 	"net"
+	"os"
+	"sync/atomic"
 	"time"
 )
 
+// DefaultReceiveQueueSize is the number of packets a BasicConn holds for its
+// reader before its DropPolicy applies.
+const DefaultReceiveQueueSize = 256
+
+// DropPolicy decides which packet a BasicConn loses when its receive queue
+// is full; the socket's reader never waits for a slow conn.
+type DropPolicy int
+
+const (
+	// DropNewest discards the arriving packet.
+	DropNewest DropPolicy = iota
+	// DropOldest discards the packet that has waited longest.
+	DropOldest
+)
+
+type ConnStats struct {
+	// Delivered counts the packets read from the conn.
+	Delivered uint64
+	// Dropped counts the packets lost to a full queue.
+	Dropped uint64
+	Queued  int
+}
+
+var errNoSocketBuffers = errors.New("transport has no socket buffers to size")
+
 type WriteToFunc func(ps []byte, addr net.Addr) (n int, err error)
 
+// writeFunc is a WriteToFunc that gives up once timeout is closed.
+type writeFunc func(ps []byte, addr net.Addr, timeout <-chan struct{}) (n int, err error)
+
+// packet carries the address it came from by value; ReadFrom only turns
+// it into an *Addr for the packets that are delivered.
 type packet struct {
-	data []byte
-	num  int
-	err  error
-	addr net.Addr
+	data   []byte
+	num    int
+	err    error
+	from   Addr
+	buffer *[]byte
 }
 
 type BasicConn struct {
 	net.PacketConn
+	// UDPConn is the transport if it is a UDP socket, nil otherwise.
 	UDPConn         *net.UDPConn
+	conn            net.PacketConn
 	isClient        bool
-	packetChan      chan *packet
+	packetChan      chan packet
+	dropPolicy      DropPolicy
+	delivered       atomic.Uint64
+	dropped         atomic.Uint64
+	bytesReceived   atomic.Uint64
+	bytesSent       atomic.Uint64
+	remote          atomic.Pointer[net.Addr]
+	lastFrom        atomic.Pointer[Addr]
+	created         time.Time
 	rAddr           net.Addr
 	lAddr           net.Addr
-	writeToFunc     WriteToFunc
+	write           writeFunc
 	context         context.Context
 	closeContext    context.Context
 	closeCancelFunc context.CancelFunc
+	readDeadline    *deadline
+	writeDeadline   *deadline
+}
 
-	timeOutCloseContext    context.Context
-	timeOutCloseCancelFunc context.CancelFunc
+func NewBasicConn(conn net.PacketConn, writeToFunc WriteToFunc, lAddr net.Addr, ctx context.Context) *BasicConn {
+	write := func(ps []byte, addr net.Addr, timeout <-chan struct{}) (int, error) {
+		return writeToFunc(ps, addr)
+	}
+	return newBasicConn(conn, write, lAddr, ctx, DefaultReceiveQueueSize, DropNewest)
 }
 
-func NewBasicConn(conn *net.UDPConn, writeToFunc WriteToFunc, lAddr net.Addr, ctx context.Context) *BasicConn {
+func newBasicConn(conn net.PacketConn, write writeFunc, lAddr net.Addr, ctx context.Context, queueSize int, dropPolicy DropPolicy) *BasicConn {
 
 	closeContext, closeCancelFunc := context.WithCancel(context.Background())
+	udpConn, _ := conn.(*net.UDPConn)
 
 	return &BasicConn{
-		UDPConn:         conn,
+		UDPConn:         udpConn,
+		conn:            conn,
 		isClient:        false,
 		lAddr:           lAddr,
-		writeToFunc:     writeToFunc,
-		packetChan:      make(chan *packet),
+		write:           write,
+		packetChan:      make(chan packet, queueSize),
+		dropPolicy:      dropPolicy,
+		created:         time.Now(),
 		context:         ctx,
 		closeContext:    closeContext,
 		closeCancelFunc: closeCancelFunc,
+		readDeadline:    newDeadline(),
+		writeDeadline:   newDeadline(),
 	}
 }
 
+// SetReadBuffer and SetWriteBuffer size the buffers of the transport, if it
+// has SetReadBuffer and SetWriteBuffer methods like a UDP socket.
 func (c *BasicConn) SetReadBuffer(bytes int) error {
-	return c.UDPConn.SetReadBuffer(bytes)
+	if conn, ok := c.conn.(interface{ SetReadBuffer(int) error }); ok {
+		return conn.SetReadBuffer(bytes)
+	}
+	return errNoSocketBuffers
 }
 
 func (c *BasicConn) SetWriteBuffer(bytes int) error {
-	return c.UDPConn.SetWriteBuffer(bytes)
+	if conn, ok := c.conn.(interface{ SetWriteBuffer(int) error }); ok {
+		return conn.SetWriteBuffer(bytes)
+	}
+	return errNoSocketBuffers
+}
+
+// SetDeadline and its read and write variants follow net.PacketConn: once
+// a deadline passes, calls fail with os.ErrDeadlineExceeded and the conn
+// stays usable. A write only ever waits for room in the socket's send queue,
+// and no longer than its deadline.
+func (c *BasicConn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
 }
 
 func (c *BasicConn) SetReadDeadline(t time.Time) error {
-	if c.timeOutCloseContext != nil && c.timeOutCloseContext.Err() == nil {
-		c.timeOutCloseCancelFunc()
-	}
-	if c.closeContext.Err() != nil {
-		return c.closeContext.Err()
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *BasicConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
+
+// handlePacket queues packet without blocking. The readers of all sockets
+// may call it at once: every packet is then still either queued or counted
+// as dropped, but with DropOldest a packet that lost the slot it freed to
+// another one is dropped as well.
+func (c *BasicConn) handlePacket(packet packet) {
+	select {
+	case c.packetChan <- packet:
+		return
+	default:
 	}
-	c.timeOutCloseContext, c.timeOutCloseCancelFunc = context.WithDeadline(context.Background(), t)
-	go func() {
+	if c.dropPolicy == DropOldest {
 		select {
-		case <-c.timeOutCloseContext.Done():
-			{
-				err := c.timeOutCloseContext.Err()
-				if errors.Is(err, context.DeadlineExceeded) {
-					c.Close()
-				}
-			}
+		case oldest := <-c.packetChan:
+			putBuffer(oldest.buffer)
+			c.dropped.Add(1)
+		default:
 		}
-	}()
-	return nil
+		select {
+		case c.packetChan <- packet:
+			return
+		default:
+		}
+	}
+	putBuffer(packet.buffer)
+	c.dropped.Add(1)
+}
+
+func (c *BasicConn) info() ConnInfo {
+	lAddr := c.lAddr.(*Addr)
+	info := ConnInfo{
+		Seq:           lAddr.seq & 0x7FFF,
+		Role:          RoleServer,
+		Service:       lAddr.service,
+		Created:       c.created,
+		BytesReceived: c.bytesReceived.Load(),
+		BytesSent:     c.bytesSent.Load(),
+		Stats:         c.Stats(),
+	}
+	if c.isClient {
+		info.Role = RoleClient
+	}
+	if remote := c.remote.Load(); remote != nil {
+		info.RemoteAddr = *remote
+	}
+	return info
 }
-func (c *BasicConn) handlePacket(packet *packet) {
-	c.packetChan <- packet
+
+func (c *BasicConn) Stats() ConnStats {
+	return ConnStats{Delivered: c.delivered.Load(), Dropped: c.dropped.Load(), Queued: len(c.packetChan)}
 }
 func (c *BasicConn) Close() error {
 	c.closeCancelFunc()
@@ -93,34 +202,76 @@ func (c *BasicConn) WaitClose() {
 }
 
 func (c *BasicConn) ReadFrom(p []byte) (n int, addr net.Addr, err error) {
+	timeout := c.readDeadline.wait()
+	if isClosed(timeout) {
+		return 0, nil, os.ErrDeadlineExceeded
+	}
 	select {
 	case packet := <-c.packetChan:
 		{
-			copy(p, packet.data)
-			return packet.num, packet.addr, packet.err
+			n := copy(p, packet.data[:packet.num])
+			putBuffer(packet.buffer)
+			c.delivered.Add(1)
+			c.bytesReceived.Add(uint64(n))
+			return n, c.fromAddr(packet.from), packet.err
 		}
 	case <-c.context.Done():
 		c.Close()
 		return 0, nil, net.ErrClosed
 	case <-c.closeContext.Done():
 		return 0, nil, net.ErrClosed
+	case <-timeout:
+		return 0, nil, os.ErrDeadlineExceeded
 	}
 }
 
+// fromAddr hands out the address of a read packet, the same *Addr as for
+// the packet before while the peer stays the same.
+func (c *BasicConn) fromAddr(from Addr) *Addr {
+	if last := c.lastFrom.Load(); last != nil && sameFrom(last, &from) {
+		return last
+	}
+	addr := new(Addr)
+	*addr = from
+	c.lastFrom.Store(addr)
+	return addr
+}
+
+func sameFrom(a, b *Addr) bool {
+	return a.seq == b.seq && a.gen == b.gen && a.service == b.service && a.plain == b.plain && samePeer(a.Addr, b.Addr)
+}
+
 func (c *BasicConn) WriteTo(ps []byte, rAddr net.Addr) (n int, err error) {
+	if c.closeContext.Err() != nil {
+		return 0, net.ErrClosed
+	}
+	timeout := c.writeDeadline.wait()
+	if isClosed(timeout) {
+		return 0, os.ErrDeadlineExceeded
+	}
+	n, err = c.writeTo(ps, rAddr, timeout)
+	c.bytesSent.Add(uint64(n))
+	if c.isClient && c.remote.Load() == nil {
+		remote := unwrapAddr(rAddr)
+		c.remote.CompareAndSwap(nil, &remote)
+	}
+	return n, err
+}
+
+func (c *BasicConn) writeTo(ps []byte, rAddr net.Addr, timeout <-chan struct{}) (n int, err error) {
 	addr, ok := rAddr.(*net.UDPAddr)
 	if ok {
 		if c.isClient {
 			lAddr := c.lAddr.(*Addr)
-			rAddr := NewAddr(addr, lAddr.seq&0x7FFF)
-			return c.writeToFunc(ps, rAddr)
+			rAddr := &Addr{Addr: addr, seq: lAddr.seq & 0x7FFF, gen: lAddr.gen, service: lAddr.service}
+			return c.write(ps, rAddr, timeout)
 		} else {
 			lAddr := c.lAddr.(*Addr)
-			rAddr := NewAddr(addr, lAddr.seq|0x8000)
-			return c.writeToFunc(ps, rAddr)
+			rAddr := &Addr{Addr: addr, seq: lAddr.seq | 0x8000, service: lAddr.service}
+			return c.write(ps, rAddr, timeout)
 		}
 	}
-	return c.writeToFunc(ps, rAddr)
+	return c.write(ps, rAddr, timeout)
 }
 func (c *BasicConn) LocalAddr() net.Addr {
 	return c.lAddr
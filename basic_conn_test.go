@@ -0,0 +1,120 @@
+package kuic
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStuckConnDoesNotStallOthers(t *testing.T) {
+	listener, err := ListenConfig(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, &Config{ReceiveQueueSize: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	stuck, err := listener.baseServer.GetClientConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	live, err := listener.baseServer.GetClientConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	for i := 0; i < 20; i++ {
+		sender.WriteTo(appendHeader([]byte{byte(i)}, header{seq: stuck.LocalAddr().(*Addr).seq}), listener.Addr())
+	}
+	for i := 0; i < 3; i++ {
+		sender.WriteTo(appendHeader([]byte{byte(i)}, header{seq: live.LocalAddr().(*Addr).seq}), listener.Addr())
+	}
+	data := make([]byte, MaxPacketBufferSize)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		live.Close()
+	}()
+	for i := 0; i < 3; i++ {
+		n, _, err := live.ReadFrom(data)
+		if err != nil {
+			t.Fatalf("live conn stalled after %d packets: %v", i, err)
+		}
+		if n != 1 || data[0] != byte(i) {
+			t.Fatalf("unexpected packet %v", data[:n])
+		}
+	}
+	if stats := live.Stats(); stats.Delivered != 3 || stats.Dropped != 0 {
+		t.Fatalf("live conn stats %+v", stats)
+	}
+	if stats := stuck.Stats(); stats.Delivered != 0 || stats.Dropped != 16 || stats.Queued != 4 {
+		t.Fatalf("stuck conn stats %+v", stats)
+	}
+}
+
+func TestDropOldest(t *testing.T) {
+	conn := newBasicConn(nil, nil, NewAddr(&net.UDPAddr{}, 0), context.Background(), 2, DropOldest)
+	for i := 0; i < 3; i++ {
+		buffer := getBuffer()
+		(*buffer)[0] = byte(i)
+		conn.handlePacket(packet{num: 1, data: *buffer, buffer: buffer})
+	}
+	data := make([]byte, MaxPacketBufferSize)
+	for _, want := range []byte{1, 2} {
+		if _, _, err := conn.ReadFrom(data); err != nil || data[0] != want {
+			t.Fatalf("read %d, want %d (%v)", data[0], want, err)
+		}
+	}
+	if stats := conn.Stats(); stats.Delivered != 2 || stats.Dropped != 1 || stats.Queued != 0 {
+		t.Fatalf("stats %+v", stats)
+	}
+}
+
+func TestDropOldestConcurrent(t *testing.T) {
+	conn := newBasicConn(nil, nil, NewAddr(&net.UDPAddr{}, 0), context.Background(), 4, DropOldest)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				buffer := getBuffer()
+				conn.handlePacket(packet{num: 1, data: *buffer, buffer: buffer})
+			}
+		}()
+	}
+	wg.Wait()
+	if stats := conn.Stats(); stats.Queued != 4 || stats.Dropped+uint64(stats.Queued) != 4000 {
+		t.Fatalf("stats %+v do not account for 4000 packets", stats)
+	}
+}
+
+func TestDropDoesNotAllocate(t *testing.T) {
+	listener := listenLocal(t)
+	bs := listener.baseServer
+	conn, err := bs.GetClientConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	from := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+	data := appendHeader(make([]byte, 64), header{seq: conn.LocalAddr().(*Addr).seq})
+	receive := func() {
+		buffer := getBuffer()
+		n := copy(*buffer, data)
+		if !bs.receive(buffer, n, from) {
+			putBuffer(buffer)
+		}
+	}
+	for i := 0; i < DefaultReceiveQueueSize; i++ {
+		receive()
+	}
+	if allocs := testing.AllocsPerRun(100, receive); allocs != 0 {
+		t.Fatalf("dropping a packet allocated %v times", allocs)
+	}
+}
@@ -0,0 +1,139 @@
+package kuic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+var (
+	ErrCandidatesFailed = errors.New("no candidate address could be dialed")
+	errNoCandidateAddr  = errors.New("candidate has no address")
+)
+
+// DefaultCandidateDelay is how long DialCandidates gives an attempt before
+// starting the next one, the delay RFC 8305 recommends.
+const DefaultCandidateDelay = 250 * time.Millisecond
+
+// Candidate is one address a peer may be reached at: a *net.UDPAddr, or a
+// *RelayAddr on a relay the listener is registered with.
+type Candidate struct {
+	Addr net.Addr
+	// Punch punches a hole towards Addr before the handshake, for addresses
+	// behind a NAT; the peer has to punch back.
+	Punch bool
+}
+
+func (c Candidate) String() string {
+	if c.Addr == nil {
+		return "<nil>"
+	}
+	return c.Addr.String()
+}
+
+type candidateResult struct {
+	index int
+	conn  Connection
+	err   error
+}
+
+func (bs *baseServer) dialCandidate(ctx context.Context, candidate Candidate, config *Config) (Connection, error) {
+	switch addr := candidate.Addr.(type) {
+	case *net.UDPAddr:
+		if addr == nil {
+			return nil, errNoCandidateAddr
+		}
+		if candidate.Punch {
+			if err := bs.punch(ctx, addr); err != nil {
+				return nil, err
+			}
+		}
+	case *RelayAddr:
+		if addr == nil || addr.Relay == nil {
+			return nil, errNoCandidateAddr
+		}
+		if !validPeerID(addr.PeerID) {
+			return nil, ErrRelayPeerID
+		}
+		if _, ok := bs.relayClient.get(addr.Relay.String()); !ok {
+			return nil, ErrRelayNotRegistered
+		}
+	case nil:
+		return nil, errNoCandidateAddr
+	default:
+		return nil, net.InvalidAddrError("not a UDP or relay address")
+	}
+	return bs.dialContext(ctx, candidate.Addr, DefaultService, config)
+}
+
+// dialCandidates races handshakes to candidates in order, each started a
+// delay after the one before or as soon as it failed. The first to complete
+// wins; the others are canceled, or closed if they complete too, which
+// hands their seqs back.
+func (bs *baseServer) dialCandidates(ctx context.Context, candidates []Candidate, config *Config) (Connection, Candidate, error) {
+	if len(candidates) == 0 {
+		return nil, Candidate{}, fmt.Errorf("%w: no candidates", ErrCandidatesFailed)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan candidateResult, len(candidates))
+	var errs []error
+	next, pending := 0, 0
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for next < len(candidates) || pending > 0 {
+		var start <-chan time.Time
+		if next < len(candidates) {
+			start = timer.C
+		}
+		select {
+		case <-start:
+			go func(index int) {
+				conn, err := bs.dialCandidate(ctx, candidates[index], config)
+				results <- candidateResult{index: index, conn: conn, err: err}
+			}(next)
+			next++
+			pending++
+			timer.Reset(config.candidateDelay())
+		case result := <-results:
+			pending--
+			if result.err == nil {
+				go closeLosers(results, pending)
+				return result.conn, candidates[result.index], nil
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", candidates[result.index], result.err))
+			// the next attempt starts right away
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(0)
+		case <-ctx.Done():
+			go closeLosers(results, pending)
+			return nil, Candidate{}, ctx.Err()
+		}
+	}
+	return nil, Candidate{}, fmt.Errorf("%w: %w", ErrCandidatesFailed, errors.Join(errs...))
+}
+
+// closeLosers waits for the attempts still pending after a dial of
+// candidates returned and closes those that got through anyway.
+func closeLosers(results chan candidateResult, pending int) {
+	for ; pending > 0; pending-- {
+		if result := <-results; result.err == nil {
+			result.conn.Close()
+		}
+	}
+}
+
+// DialCandidates dials whichever of a peer's candidate addresses answers
+// first, happy eyeballs style: attempts start in the order given, spaced by
+// the listener's CandidateDelay. It returns the connection and the candidate
+// it went to.
+func (l *Listener) DialCandidates(ctx context.Context, candidates []Candidate) (Connection, Candidate, error) {
+	return l.baseServer.dialCandidates(ctx, candidates, l.baseServer.config)
+}
@@ -0,0 +1,86 @@
+package kuic
+
+import (
+	"context"
+	"errors"
+	"github.com/chuccp/kuic/netsim"
+	"net"
+	"testing"
+	"time"
+)
+
+// waitSeqsInUse waits for the dials of listener to hold n seqs.
+func waitSeqsInUse(t *testing.T, listener *Listener, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for listener.SeqStats().InUse != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d seqs in use, expected %d", listener.SeqStats().InUse, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDialCandidates(t *testing.T) {
+	network := netsim.New(netsim.LinkConfig{Delay: 5 * time.Millisecond}, 1)
+	a := listenSimConfig(t, network, "10.0.0.1", &Config{CandidateDelay: 50 * time.Millisecond})
+	b := listenSim(t, network, "10.0.0.2")
+	c := listenSim(t, network, "10.0.0.3")
+	echoServer(b)
+	echoServer(c)
+	dead := &net.UDPAddr{IP: net.ParseIP("10.0.9.9"), Port: 9}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, test := range []struct {
+		name       string
+		candidates []Candidate
+		won        net.Addr
+	}{
+		{"first answers", []Candidate{{Addr: b.Addr()}, {Addr: c.Addr()}}, b.Addr()},
+		{"first silent", []Candidate{{Addr: dead}, {Addr: c.Addr()}}, c.Addr()},
+		{"first refused", []Candidate{{Addr: &RelayAddr{Relay: dead, PeerID: "b"}}, {Addr: b.Addr()}}, b.Addr()},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			conn, won, err := a.DialCandidates(ctx, test.candidates)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if won.Addr != test.won {
+				t.Fatalf("dialed %v, expected %v", won, test.won)
+			}
+			if err := echo(conn); err != nil {
+				t.Fatal(err)
+			}
+			waitSeqsInUse(t, a, 1)
+		})
+	}
+}
+
+func TestDialCandidatesFail(t *testing.T) {
+	network := netsim.New(netsim.LinkConfig{}, 1)
+	a := listenSimConfig(t, network, "10.0.0.1", &Config{CandidateDelay: 20 * time.Millisecond})
+	if _, _, err := a.DialCandidates(context.Background(), nil); !errors.Is(err, ErrCandidatesFailed) {
+		t.Fatalf("expected ErrCandidatesFailed without candidates, got %v", err)
+	}
+	var nilAddr *net.UDPAddr
+	_, _, err := a.DialCandidates(context.Background(), []Candidate{{}, {Addr: nilAddr}, {Addr: &RelayAddr{Relay: &net.UDPAddr{}, PeerID: ""}}})
+	if !errors.Is(err, ErrCandidatesFailed) || !errors.Is(err, errNoCandidateAddr) || !errors.Is(err, ErrRelayPeerID) {
+		t.Fatalf("expected the candidates' errors, got %v", err)
+	}
+	relay := &net.UDPAddr{IP: net.ParseIP("10.0.9.9"), Port: 9}
+	_, _, err = a.DialCandidates(context.Background(), []Candidate{{Addr: &RelayAddr{Relay: relay, PeerID: "b"}}})
+	if !errors.Is(err, ErrCandidatesFailed) || !errors.Is(err, ErrRelayNotRegistered) {
+		t.Fatalf("expected the relay's error, got %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	var candidates []Candidate
+	for i := 1; i <= 3; i++ {
+		candidates = append(candidates, Candidate{Addr: &net.UDPAddr{IP: net.IPv4(10, 0, 9, byte(i)), Port: 9}})
+	}
+	if _, _, err := a.DialCandidates(ctx, candidates); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline, got %v", err)
+	}
+	waitSeqsInUse(t, a, 0)
+}
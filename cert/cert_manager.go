@@ -125,8 +125,16 @@ func ParseClientKuicCertBytes(data []byte) (*Certificate, error) {
 	if err != nil {
 		return nil, err
 	}
-	serverName := ce.DNSNames[0]
-	username := string(ce.SubjectKeyId)
+	serverName, username := ParseIdentity(ce)
 	certificate := &Certificate{Cert: &cert, CaPem: pem.EncodeToMemory(serverCaBlock), ServerName: serverName, UserName: username}
 	return certificate, nil
 }
+
+// ParseIdentity returns the server name and username a kuic certificate
+// carries in its first DNS name and its SubjectKeyId.
+func ParseIdentity(ce *x509.Certificate) (serverName string, username string) {
+	if len(ce.DNSNames) > 0 {
+		serverName = ce.DNSNames[0]
+	}
+	return serverName, string(ce.SubjectKeyId)
+}
@@ -0,0 +1,139 @@
+package kuic
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/chuccp/kuic/cert"
+	"github.com/quic-go/quic-go"
+	"time"
+)
+
+const NextProto = "kuic"
+
+// Config configures the QUIC transport of a Listener. A nil Config, like any
+// nil field, falls back to the defaults: a throwaway self-signed server
+// certificate and a client that skips verification.
+type Config struct {
+	// TLSConfig is used for connections accepted by the listener.
+	TLSConfig *tls.Config
+	// TLSClientConfig is used for connections the listener dials.
+	TLSClientConfig *tls.Config
+	QuicConfig      *quic.Config
+	// LegacyFraming accepts packets framed the way kuic did before the
+	// versioned header, and answers their senders the same way.
+	LegacyFraming bool
+	// Interop lets stock QUIC clients, which do not add kuic's header,
+	// connect to the server role of the listener on the same port.
+	Interop bool
+	// ReceiveQueueSize bounds the packets each virtual conn holds for
+	// quic-go, DefaultReceiveQueueSize if zero; DropPolicy decides which
+	// packet goes when it is full.
+	ReceiveQueueSize int
+	DropPolicy       DropPolicy
+	// SeqQuarantine is how long the seq of a closed dial rests before reuse,
+	// DefaultSeqQuarantine if zero.
+	SeqQuarantine time.Duration
+	// CandidateDelay staggers the attempts of DialCandidates,
+	// DefaultCandidateDelay if zero.
+	CandidateDelay time.Duration
+}
+
+func (c *Config) Clone() *Config {
+	if c == nil {
+		return &Config{}
+	}
+	clone := *c
+	return &clone
+}
+
+func withNextProto(tlsConf *tls.Config) *tls.Config {
+	if len(tlsConf.NextProtos) > 0 {
+		return tlsConf
+	}
+	tlsConf = tlsConf.Clone()
+	tlsConf.NextProtos = []string{NextProto}
+	return tlsConf
+}
+
+// ServerTLSConfig is the TLS config connections are accepted with:
+// TLSConfig, or a throwaway self-signed certificate.
+func (c *Config) ServerTLSConfig() *tls.Config {
+	if c == nil || c.TLSConfig == nil {
+		return generateTLSConfig()
+	}
+	return withNextProto(c.TLSConfig)
+}
+
+func (c *Config) clientTLSConfig() *tls.Config {
+	if c == nil || c.TLSClientConfig == nil {
+		return &tls.Config{
+			InsecureSkipVerify: true,
+			NextProtos:         []string{NextProto},
+		}
+	}
+	return withNextProto(c.TLSClientConfig)
+}
+
+func (c *Config) receiveQueueSize() int {
+	if c == nil || c.ReceiveQueueSize <= 0 {
+		return DefaultReceiveQueueSize
+	}
+	return c.ReceiveQueueSize
+}
+
+func (c *Config) seqQuarantine() time.Duration {
+	if c == nil || c.SeqQuarantine <= 0 {
+		return DefaultSeqQuarantine
+	}
+	return c.SeqQuarantine
+}
+
+func (c *Config) candidateDelay() time.Duration {
+	if c == nil || c.CandidateDelay <= 0 {
+		return DefaultCandidateDelay
+	}
+	return c.CandidateDelay
+}
+
+func (c *Config) dropPolicy() DropPolicy {
+	if c == nil {
+		return DropNewest
+	}
+	return c.DropPolicy
+}
+
+// TransportConfig is the quic.Config kuic listens and dials with. It always
+// negotiates the datagram extension, so that SendDatagram works on every
+// kuic connection.
+func (c *Config) TransportConfig() *quic.Config {
+	conf := &quic.Config{}
+	if c != nil && c.QuicConfig != nil {
+		conf = c.QuicConfig.Clone()
+	}
+	conf.EnableDatagrams = true
+	return conf
+}
+
+// managerTLSConfig presents the manager's server certificate and only
+// accepts clients holding a certificate signed by its client CA.
+func managerTLSConfig(manager *cert.Manager) *tls.Config {
+	return &tls.Config{
+		ClientCAs:    manager.GetCertPool(),
+		Certificates: []tls.Certificate{*manager.GetServerCertificate()},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		NextProtos:   []string{NextProto},
+	}
+}
+
+// certificateTLSConfig verifies the server against the CA and the server
+// name (the hash of that CA) carried by a kuic client certificate.
+func certificateTLSConfig(certificate *cert.Certificate) *tls.Config {
+	caCertPool := x509.NewCertPool()
+	caCertPool.AppendCertsFromPEM(certificate.CaPem)
+	return &tls.Config{
+		Certificates: []tls.Certificate{*certificate.Cert},
+		RootCAs:      caCertPool,
+		ServerName:   certificate.ServerName,
+		NextProtos:   []string{NextProto},
+	}
+}
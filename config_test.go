@@ -0,0 +1,46 @@
+package kuic
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/quic-go/quic-go"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConfig(t *testing.T) {
+	config := &Config{QuicConfig: &quic.Config{MaxIdleTimeout: 200 * time.Millisecond}}
+	listener, err := ListenConfig(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go conn.AcceptStream(context.Background())
+		}
+	}()
+
+	conn, err := listener.Dial(listener.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(600 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := conn.(*connection).connection.OpenStreamSync(ctx); err == nil {
+		t.Fatal("connection outlived the configured idle timeout")
+	}
+
+	_, err = listener.DialWithConfig(listener.Addr(), &Config{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"h3"}},
+	})
+	if err == nil {
+		t.Fatal("dial with a foreign ALPN succeeded")
+	}
+}
@@ -2,28 +2,92 @@ package kuic
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/chuccp/kuic/cert"
 	"github.com/quic-go/quic-go"
+	"net"
 )
 
+var ErrNoPeerCertificate = errors.New("peer presented no certificate")
+
+// PeerIdentity is what a kuic certificate says about its holder, see
+// cert.ParseIdentity.
+type PeerIdentity struct {
+	ServerName  string
+	UserName    string
+	Certificate *x509.Certificate
+}
+
 type Connection interface {
-	AcceptStream() (quic.Stream, error)
-	OpenStreamSync() (quic.Stream, error)
+	AcceptStream(ctx context.Context) (quic.Stream, error)
+	AcceptUniStream(ctx context.Context) (quic.ReceiveStream, error)
+	// OpenStream fails instead of blocking when the peer's stream limit is
+	// reached, OpenStreamSync waits for the peer to allow another stream.
+	OpenStream() (quic.Stream, error)
+	OpenStreamSync(ctx context.Context) (quic.Stream, error)
+	OpenUniStream() (quic.SendStream, error)
+	OpenUniStreamSync(ctx context.Context) (quic.SendStream, error)
+	RemoteAddr() net.Addr
+	LocalAddr() net.Addr
+	ConnectionState() tls.ConnectionState
+	PeerIdentity() (*PeerIdentity, error)
+	// SendDatagram sends data unreliably, outside of any stream. It must not
+	// exceed MaxDatagramSize.
+	SendDatagram(data []byte) error
+	ReceiveDatagram(ctx context.Context) ([]byte, error)
+	MaxDatagramSize() int
 	Close() error
 }
 type connection struct {
 	connection quic.Connection
-	context    context.Context
 }
 
 func (connection *connection) Close() error {
 	return connection.connection.CloseWithError(0, "")
 }
-func (connection *connection) AcceptStream() (quic.Stream, error) {
-	return connection.connection.AcceptStream(connection.context)
+func (connection *connection) AcceptStream(ctx context.Context) (quic.Stream, error) {
+	return connection.connection.AcceptStream(ctx)
+}
+func (connection *connection) AcceptUniStream(ctx context.Context) (quic.ReceiveStream, error) {
+	return connection.connection.AcceptUniStream(ctx)
+}
+func (connection *connection) OpenStream() (quic.Stream, error) {
+	return connection.connection.OpenStream()
+}
+func (connection *connection) OpenStreamSync(ctx context.Context) (quic.Stream, error) {
+	return connection.connection.OpenStreamSync(ctx)
+}
+func (connection *connection) OpenUniStream() (quic.SendStream, error) {
+	return connection.connection.OpenUniStream()
+}
+func (connection *connection) OpenUniStreamSync(ctx context.Context) (quic.SendStream, error) {
+	return connection.connection.OpenUniStreamSync(ctx)
+}
+func unwrapAddr(addr net.Addr) net.Addr {
+	if a, ok := addr.(*Addr); ok {
+		return a.Addr
+	}
+	return addr
+}
+func (connection *connection) RemoteAddr() net.Addr {
+	return unwrapAddr(connection.connection.RemoteAddr())
+}
+func (connection *connection) LocalAddr() net.Addr {
+	return unwrapAddr(connection.connection.LocalAddr())
+}
+func (connection *connection) ConnectionState() tls.ConnectionState {
+	return connection.connection.ConnectionState().TLS
 }
-func (connection *connection) OpenStreamSync() (quic.Stream, error) {
-	return connection.connection.OpenStreamSync(connection.context)
+func (connection *connection) PeerIdentity() (*PeerIdentity, error) {
+	certificates := connection.ConnectionState().PeerCertificates
+	if len(certificates) == 0 {
+		return nil, ErrNoPeerCertificate
+	}
+	serverName, username := cert.ParseIdentity(certificates[0])
+	return &PeerIdentity{ServerName: serverName, UserName: username, Certificate: certificates[0]}, nil
 }
-func createConnection(conn quic.Connection, context context.Context) *connection {
-	return &connection{connection: conn, context: context}
+func createConnection(conn quic.Connection) *connection {
+	return &connection{connection: conn}
 }
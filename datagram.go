@@ -0,0 +1,62 @@
+package kuic
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+var ErrDatagramsDisabled = errors.New("peer does not support datagrams")
+
+const (
+	// quicPacketSize is the packet size quic-go settles on for a
+	// PacketConn whose addresses are not *net.UDPAddr, as ours are not.
+	quicPacketSize = 1200
+	// udpPayloadIPv4 and udpPayloadIPv6 are the UDP payloads quic-go
+	// considers safe without path MTU discovery.
+	udpPayloadIPv4 = 1252
+	udpPayloadIPv6 = 1232
+	// shortHeaderOverhead is a 1-RTT packet's first byte, a default length
+	// connection ID, the longest packet number and the AEAD tag.
+	shortHeaderOverhead = 1 + 4 + 4 + 16
+	// datagramFrameOverhead is the DATAGRAM frame type and length.
+	datagramFrameOverhead = 1 + 2
+)
+
+// maxDatagramSize is the largest datagram payload that fits in a single
+// packet towards addr, once kuic's own trailer (and relay header) is
+// accounted for.
+func maxDatagramSize(addr net.Addr) int {
+	budget := udpPayloadIPv4
+	overhead := headerSize
+	if relayAddr, ok := addr.(*RelayAddr); ok {
+		overhead += 1 + len(relayAddr.PeerID) + headerSize
+		addr = relayAddr.Relay
+	}
+	if udpAddr, ok := addr.(*net.UDPAddr); ok && udpAddr.IP.To4() == nil {
+		budget = udpPayloadIPv6
+	}
+	size := budget - overhead
+	if size > quicPacketSize {
+		size = quicPacketSize
+	}
+	return size - shortHeaderOverhead - datagramFrameOverhead
+}
+
+func (connection *connection) SendDatagram(data []byte) error {
+	if !connection.connection.ConnectionState().SupportsDatagrams {
+		return ErrDatagramsDisabled
+	}
+	return connection.connection.SendMessage(data)
+}
+
+func (connection *connection) ReceiveDatagram(ctx context.Context) ([]byte, error) {
+	return connection.connection.ReceiveMessage(ctx)
+}
+
+func (connection *connection) MaxDatagramSize() int {
+	if !connection.connection.ConnectionState().SupportsDatagrams {
+		return 0
+	}
+	return maxDatagramSize(connection.RemoteAddr())
+}
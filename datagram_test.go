@@ -0,0 +1,58 @@
+package kuic
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDatagram(t *testing.T) {
+	a := listenLocal(t)
+	b := listenLocal(t)
+	accepted := make(chan Connection, 1)
+	go func() {
+		conn, err := b.Accept()
+		if err != nil {
+			return
+		}
+		accepted <- conn
+	}()
+	conn, err := a.Dial(b.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	size := conn.MaxDatagramSize()
+	if size <= 0 || size > quicPacketSize-headerSize {
+		t.Fatalf("unexpected max datagram size %d", size)
+	}
+	serverConn := <-accepted
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	payload := bytes.Repeat([]byte{0x5A}, size)
+	received := make(chan []byte, 1)
+	go func() {
+		data, err := serverConn.ReceiveDatagram(ctx)
+		if err == nil {
+			received <- data
+		}
+	}()
+	// datagrams are unreliable, so keep sending until one arrives.
+	for {
+		if err := conn.SendDatagram(payload); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case data := <-received:
+			if !bytes.Equal(data, payload) {
+				t.Fatalf("received %d bytes, want %d", len(data), len(payload))
+			}
+			return
+		case <-time.After(100 * time.Millisecond):
+		case <-ctx.Done():
+			t.Fatal("datagram of MaxDatagramSize was never delivered")
+		}
+	}
+}
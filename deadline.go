@@ -0,0 +1,62 @@
+package kuic
+
+import (
+	"sync"
+	"time"
+)
+
+// deadline is the deadline of net.Pipe: done is closed once it has passed,
+// and replaced when it is moved into the future again.
+type deadline struct {
+	timer  *time.Timer
+	done   chan struct{}
+	locker *sync.Mutex
+}
+
+func newDeadline() *deadline {
+	return &deadline{done: make(chan struct{}), locker: new(sync.Mutex)}
+}
+
+func (d *deadline) set(t time.Time) {
+	d.locker.Lock()
+	defer d.locker.Unlock()
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.done // the timer fired, wait for it to close done
+	}
+	d.timer = nil
+	expired := isClosed(d.done)
+	if t.IsZero() {
+		if expired {
+			d.done = make(chan struct{})
+		}
+		return
+	}
+	if wait := time.Until(t); wait > 0 {
+		if expired {
+			d.done = make(chan struct{})
+		}
+		done := d.done
+		d.timer = time.AfterFunc(wait, func() {
+			close(done)
+		})
+		return
+	}
+	if !expired {
+		close(d.done)
+	}
+}
+
+func (d *deadline) wait() chan struct{} {
+	d.locker.Lock()
+	defer d.locker.Unlock()
+	return d.done
+}
+
+func isClosed(c chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,261 @@
+package kuic
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func baseServerLocal(t *testing.T) *baseServer {
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		udpConn.Close()
+	})
+	return NewBaseServer(udpConn, ctx)
+}
+
+// packetConnPair returns a client conn of one base server and the server
+// conn of another, which the client reaches at serverAddr.
+func packetConnPair(t *testing.T) (client, server *BasicConn, serverAddr net.Addr) {
+	server, err := baseServerLocal(t).GetServerConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err = baseServerLocal(t).GetClientConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, server, server.UDPConn.LocalAddr()
+}
+
+func checkTimeout(t *testing.T, err error) {
+	t.Helper()
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+}
+
+func roundTrip(t *testing.T, client, server *BasicConn, serverAddr net.Addr) {
+	t.Helper()
+	if _, err := client.WriteTo([]byte("ping"), serverAddr); err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, MaxPacketBufferSize)
+	server.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, addr, err := server.ReadFrom(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.WriteTo(data[:n], addr); err != nil {
+		t.Fatal(err)
+	}
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if n, _, err = client.ReadFrom(data); err != nil {
+		t.Fatal(err)
+	}
+	if string(data[:n]) != "ping" {
+		t.Fatalf("unexpected echo %q", data[:n])
+	}
+}
+
+func TestPacketConnPastTimeout(t *testing.T) {
+	client, server, serverAddr := packetConnPair(t)
+	client.SetDeadline(time.Now().Add(-time.Second))
+	for i := 0; i < 3; i++ {
+		_, _, err := client.ReadFrom(make([]byte, 16))
+		checkTimeout(t, err)
+		_, err = client.WriteTo([]byte("late"), serverAddr)
+		checkTimeout(t, err)
+	}
+	client.SetDeadline(time.Time{})
+	roundTrip(t, client, server, serverAddr)
+}
+
+func TestPacketConnFutureTimeout(t *testing.T) {
+	client, server, serverAddr := packetConnPair(t)
+	timeout := 100 * time.Millisecond
+	start := time.Now()
+	client.SetReadDeadline(start.Add(timeout))
+	_, _, err := client.ReadFrom(make([]byte, 16))
+	checkTimeout(t, err)
+	if elapsed := time.Since(start); elapsed < timeout || elapsed > timeout+time.Second {
+		t.Fatalf("read timed out after %v, expected %v", elapsed, timeout)
+	}
+	// the write deadline is independent of the read deadline
+	roundTrip(t, client, server, serverAddr)
+}
+
+func TestPacketConnMovedDeadline(t *testing.T) {
+	client, server, serverAddr := packetConnPair(t)
+	client.SetReadDeadline(time.Now().Add(time.Hour))
+	errs := make(chan error, 1)
+	go func() {
+		_, _, err := client.ReadFrom(make([]byte, 16))
+		errs <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	client.SetReadDeadline(time.Now())
+	select {
+	case err := <-errs:
+		checkTimeout(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("blocked read ignored the new deadline")
+	}
+
+	client.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	go func() {
+		_, _, err := client.ReadFrom(make([]byte, 16))
+		errs <- err
+	}()
+	client.SetReadDeadline(time.Time{})
+	select {
+	case err := <-errs:
+		t.Fatalf("read returned after its deadline was cleared: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	server.WriteTo([]byte("late"), &Addr{Addr: client.UDPConn.LocalAddr(), seq: client.LocalAddr().(*Addr).seq, gen: client.LocalAddr().(*Addr).gen})
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, client, server, serverAddr)
+}
+
+func TestPacketConnClose(t *testing.T) {
+	client, _, serverAddr := packetConnPair(t)
+	errs := make(chan error, 1)
+	go func() {
+		_, _, err := client.ReadFrom(make([]byte, 16))
+		errs <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	client.Close()
+	if err := <-errs; !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected net.ErrClosed, got %v", err)
+	}
+	if _, err := client.WriteTo([]byte("closed"), serverAddr); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected net.ErrClosed, got %v", err)
+	}
+	if _, _, err := client.ReadFrom(make([]byte, 16)); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected net.ErrClosed, got %v", err)
+	}
+}
+
+func TestPacketConnConcurrentMethods(t *testing.T) {
+	client, server, serverAddr := packetConnPair(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(4)
+		go func() {
+			defer wg.Done()
+			client.SetDeadline(time.Now().Add(20 * time.Millisecond))
+		}()
+		go func() {
+			defer wg.Done()
+			client.SetWriteDeadline(time.Time{})
+			client.WriteTo([]byte("concurrent"), serverAddr)
+		}()
+		go func() {
+			defer wg.Done()
+			client.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+			client.ReadFrom(make([]byte, 16))
+		}()
+		go func() {
+			defer wg.Done()
+			client.LocalAddr()
+			client.Stats()
+		}()
+	}
+	wg.Wait()
+	client.SetDeadline(time.Time{})
+	if _, err := client.WriteTo([]byte("ping"), serverAddr); err != nil {
+		t.Fatal(err)
+	}
+	server.SetReadDeadline(time.Now().Add(2 * time.Second))
+	data := make([]byte, 16)
+	for {
+		n, _, err := server.ReadFrom(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data[:n]) == "ping" {
+			return
+		}
+	}
+}
+
+// stalledConn is a transport whose writes block until released.
+type stalledConn struct {
+	*net.UDPConn
+	released chan struct{}
+}
+
+func (c *stalledConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	<-c.released
+	return c.UDPConn.WriteTo(p, addr)
+}
+
+func TestPacketConnWriteTimeoutOnFullQueue(t *testing.T) {
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udpConn.Close()
+	conn := &stalledConn{UDPConn: udpConn, released: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client, err := NewBaseServer(conn, ctx).GetClientConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer close(conn.released)
+	peer := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9}
+
+	timeout := 100 * time.Millisecond
+	client.SetWriteDeadline(time.Now().Add(timeout))
+	start := time.Now()
+	written := 0
+	for {
+		_, err = client.WriteTo([]byte("queued"), peer)
+		if err != nil {
+			break
+		}
+		written++
+	}
+	checkTimeout(t, err)
+	if written < sendQueueSize {
+		t.Fatalf("timed out after %d writes, before the queue of %d filled", written, sendQueueSize)
+	}
+	if elapsed := time.Since(start); elapsed < timeout || elapsed > timeout+time.Second {
+		t.Fatalf("write timed out after %v, expected %v", elapsed, timeout)
+	}
+
+	// a deadline set while a write waits for the queue ends that write
+	client.SetWriteDeadline(time.Time{})
+	errs := make(chan error, 1)
+	go func() {
+		_, err := client.WriteTo([]byte("blocked"), peer)
+		errs <- err
+	}()
+	select {
+	case err := <-errs:
+		t.Fatalf("write to a full queue returned: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	client.SetWriteDeadline(time.Now())
+	select {
+	case err := <-errs:
+		checkTimeout(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("blocked write ignored the new deadline")
+	}
+}
@@ -0,0 +1,137 @@
+package kuic
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+type header struct {
+	typ     byte
+	flags   byte
+	service ServiceID
+	gen     byte
+	seq     uint16
+}
+
+func appendHeader(data []byte, h header) []byte {
+	return append(data, h.typ, h.flags, byte(h.service), h.gen, byte(h.seq>>8), byte(h.seq), headerVersion, byte(headerMagic>>8), byte(headerMagic&0xFF))
+}
+
+// parseHeader returns the header of data and the payload in front of it.
+// Packets without the magic, of another version or of an unknown type are
+// rejected.
+func parseHeader(data []byte) (header, []byte, bool) {
+	n := len(data) - headerSize
+	if n < 0 {
+		return header{}, nil, false
+	}
+	if uint16(data[n+7])<<8|uint16(data[n+8]) != headerMagic || data[n+6] != headerVersion || data[n] > maxPacketType {
+		return header{}, nil, false
+	}
+	return header{typ: data[n], flags: data[n+1], service: ServiceID(data[n+2]), gen: data[n+3], seq: uint16(data[n+4])<<8 | uint16(data[n+5])}, data[:n], true
+}
+
+func appendLegacyHeader(data []byte, seq uint16) []byte {
+	return append(data, byte(seq>>8), byte(seq))
+}
+
+func parseLegacyHeader(data []byte) (header, []byte, bool) {
+	n := len(data) - legacyHeaderSize
+	if n < 0 {
+		return header{}, nil, false
+	}
+	seq := uint16(data[n])<<8 | uint16(data[n+1])
+	if seq != legacyControlSeq {
+		return header{typ: packetQuic, seq: seq}, data[:n], true
+	}
+	if n < 1 || data[0] == packetQuic || data[0] > maxPacketType {
+		return header{}, nil, false
+	}
+	return header{typ: data[0]}, data[1:n], true
+}
+
+// framing remembers the peers that still use the legacy trailer, so they are
+// answered in kind, and counts the packets that are not kuic's at all.
+type framing struct {
+	legacy       map[string]bool
+	count        atomic.Int32
+	acceptLegacy atomic.Bool
+	rejected     atomic.Uint64
+	locker       *sync.RWMutex
+}
+
+func newFraming() *framing {
+	return &framing{legacy: make(map[string]bool), locker: new(sync.RWMutex)}
+}
+
+func (f *framing) isLegacy(addr net.Addr) bool {
+	if f.count.Load() == 0 {
+		return false
+	}
+	f.locker.RLock()
+	defer f.locker.RUnlock()
+	return f.legacy[addr.String()]
+}
+
+func (f *framing) setLegacy(addr net.Addr, legacy bool) {
+	f.locker.Lock()
+	defer f.locker.Unlock()
+	if legacy {
+		f.legacy[addr.String()] = true
+	} else {
+		delete(f.legacy, addr.String())
+	}
+	f.count.Store(int32(len(f.legacy)))
+}
+
+func (f *framing) parse(data []byte, addr net.Addr) (header, []byte, bool) {
+	legacy := f.isLegacy(addr)
+	if h, payload, ok := parseHeader(data); ok {
+		if legacy {
+			f.setLegacy(addr, false)
+		}
+		return h, payload, true
+	}
+	if legacy || f.acceptLegacy.Load() {
+		if h, payload, ok := parseLegacyHeader(data); ok {
+			if !legacy {
+				f.setLegacy(addr, true)
+			}
+			return h, payload, true
+		}
+	}
+	f.rejected.Add(1)
+	return header{}, nil, false
+}
+
+// appendPacket frames a QUIC packet for addr.
+func (f *framing) appendPacket(data []byte, a *Addr, addr net.Addr) []byte {
+	if f.isLegacy(addr) {
+		return appendLegacyHeader(data, a.seq)
+	}
+	return appendHeader(data, header{typ: packetQuic, service: a.service, gen: a.gen, seq: a.seq})
+}
+
+// appendControl frames a control packet of type typ for addr.
+func (f *framing) appendControl(data []byte, typ byte, payload []byte, addr net.Addr) []byte {
+	if f.isLegacy(addr) {
+		data = append(append(data, typ), payload...)
+		return appendLegacyHeader(data, legacyControlSeq)
+	}
+	return appendHeader(append(data, payload...), header{typ: typ})
+}
+
+// UseLegacyFraming makes the listener talk to addr with the bare 2-byte seq
+// trailer of kuic releases before the versioned header. It is only needed to
+// dial such a peer; peers that send legacy packets are answered in kind when
+// Config.LegacyFraming is set.
+func (l *Listener) UseLegacyFraming(addr *net.UDPAddr) {
+	l.baseServer.framing.setLegacy(addr, true)
+}
+
+// RejectedPackets is the number of datagrams dropped because they were not
+// framed by kuic or addressed a connection that does not exist.
+func (l *Listener) RejectedPackets() uint64 {
+	return l.baseServer.framing.rejected.Load()
+}
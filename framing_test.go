@@ -0,0 +1,102 @@
+package kuic
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseHeader(t *testing.T) {
+	data := appendHeader([]byte("quic"), header{typ: packetQuic, flags: 1, seq: 0x8003})
+	h, payload, ok := parseHeader(data)
+	if !ok || h.seq != 0x8003 || h.flags != 1 || string(payload) != "quic" {
+		t.Fatalf("parsed %+v %q %v", h, payload, ok)
+	}
+	for _, data := range [][]byte{nil, {1}, data[:len(data)-1], appendHeader(nil, header{typ: maxPacketType + 1})} {
+		if _, _, ok := parseHeader(data); ok {
+			t.Fatalf("accepted %v", data)
+		}
+	}
+	// the trailers of versions 1 and 2, which lacked service and gen
+	for _, data := range [][]byte{
+		{'q', 'u', 'i', 'c', packetQuic, 0, 0x80, 0x03, 1, 0x4B, 0x55},
+		{'q', 'u', 'i', 'c', packetQuic, 0, 1, 0x80, 0x03, 2, 0x4B, 0x55},
+	} {
+		if _, _, ok := parseHeader(data); ok {
+			t.Fatalf("accepted old header %v", data)
+		}
+	}
+	for _, data := range [][]byte{nil, {1}, {0xFF, 0xFF}, {0x7F, 0xFF, 0xFF}} {
+		if _, _, ok := parseLegacyHeader(data); ok {
+			t.Fatalf("accepted legacy %v", data)
+		}
+	}
+	h, payload, ok = parseLegacyHeader([]byte{controlRelayAllocate, 'p', 0xFF, 0xFF})
+	if !ok || h.typ != controlRelayAllocate || string(payload) != "p" {
+		t.Fatalf("parsed legacy %+v %q %v", h, payload, ok)
+	}
+}
+
+func TestRejectForeignPackets(t *testing.T) {
+	listener := listenLocal(t)
+	noise, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer noise.Close()
+	packets := [][]byte{
+		{},
+		{0x80},
+		[]byte("GET / HTTP/1.1\r\n\r\n"),
+		appendLegacyHeader([]byte("quic"), 0x8001),
+		appendHeader([]byte("quic"), header{seq: 0x8001}),
+	}
+	for _, data := range packets {
+		noise.WriteTo(data, listener.Addr())
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for listener.RejectedPackets() != uint64(len(packets)) {
+		if time.Now().After(deadline) {
+			t.Fatalf("rejected %d packets, want %d", listener.RejectedPackets(), len(packets))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLegacyFraming(t *testing.T) {
+	server, err := ListenConfig(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, &Config{LegacyFraming: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	go func() {
+		conn, err := server.Accept()
+		if err != nil {
+			return
+		}
+		stream, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		io.Copy(stream, stream)
+		stream.Close()
+	}()
+	client := listenLocal(t)
+	client.UseLegacyFraming(server.Addr())
+	conn, err := client.Dial(server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := echo(conn); err != nil {
+		t.Fatal(err)
+	}
+	if !server.baseServer.framing.isLegacy(client.Addr()) {
+		t.Fatal("server did not fall back to the legacy framing")
+	}
+	if rejected := server.RejectedPackets(); rejected != 0 {
+		t.Fatalf("server rejected %d legacy packets", rejected)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"github.com/chuccp/kuic"
 	"github.com/chuccp/kuic/cert"
+	"github.com/quic-go/quic-go"
 	"github.com/quic-go/quic-go/http3"
 	"io"
 	"net"
@@ -22,6 +23,7 @@ type ClientPool struct {
 	connMap            map[string]*kuic.BasicConn
 	reverseProxyMap    map[string]*ReverseProxy
 	tlsReverseProxyMap map[string]*ReverseProxy
+	config             *kuic.Config
 }
 
 func (cp *ClientPool) GetHttpClient(address *net.UDPAddr) (*Client, error) {
@@ -36,7 +38,12 @@ func (cp *ClientPool) GetHttpClient(address *net.UDPAddr) (*Client, error) {
 	if err != nil {
 		return nil, err
 	}
-	client = NewClient(address, conn)
+	if cp.config != nil && cp.config.TLSClientConfig != nil {
+		client = NewTlsClient(address, cp.config.TLSClientConfig, conn)
+	} else {
+		client = NewClient(address, conn)
+	}
+	client.setQuicConfig(cp.quicConfig())
 	cp.addressMap[key] = client
 	go func() {
 		conn.WaitClose()
@@ -57,6 +64,7 @@ func (cp *ClientPool) GetTlsHttpClient(address *net.UDPAddr, cert *cert.Certific
 		return nil, err
 	}
 	client = NewKuicClient(address, cert, conn)
+	client.setQuicConfig(cp.quicConfig())
 	cp.addressMap[key] = client
 	go func() {
 		conn.WaitClose()
@@ -80,6 +88,7 @@ func (cp *ClientPool) ReverseProxy(address *net.UDPAddr) (*ReverseProxy, error)
 		if err != nil {
 			return nil, err
 		}
+		proxy.setQuicConfig(cp.quicConfig())
 		cp.reverseProxyMap[key] = proxy
 		go func() {
 			conn.WaitClose()
@@ -105,6 +114,7 @@ func (cp *ClientPool) TlsReverseProxy(address *net.UDPAddr, cert *cert.Certifica
 		if err != nil {
 			return nil, err
 		}
+		proxy.setQuicConfig(cp.quicConfig())
 		cp.tlsReverseProxyMap[key] = proxy
 		go func() {
 			conn.WaitClose()
@@ -163,8 +173,18 @@ func (cp *ClientPool) getClientTlsConn(address *net.UDPAddr) (*kuic.BasicConn, e
 	return conn, nil
 }
 
+func (cp *ClientPool) quicConfig() *quic.Config {
+	return cp.config.TransportConfig()
+}
+
 func NewClientPool(baseServer kuic.BaseServer) *ClientPool {
-	return &ClientPool{lock: new(sync.RWMutex), baseServer: baseServer, addressMap: make(map[string]*Client), connMap: make(map[string]*kuic.BasicConn), tlsReverseProxyMap: make(map[string]*ReverseProxy), reverseProxyMap: make(map[string]*ReverseProxy)}
+	return NewClientPoolWithConfig(baseServer, nil)
+}
+
+// NewClientPoolWithConfig is NewClientPool for clients that dial with the
+// QUIC settings of config.
+func NewClientPoolWithConfig(baseServer kuic.BaseServer, config *kuic.Config) *ClientPool {
+	return &ClientPool{lock: new(sync.RWMutex), baseServer: baseServer, config: config, addressMap: make(map[string]*Client), connMap: make(map[string]*kuic.BasicConn), tlsReverseProxyMap: make(map[string]*ReverseProxy), reverseProxyMap: make(map[string]*ReverseProxy)}
 }
 
 type Client struct {
@@ -185,6 +205,10 @@ func (c *Client) Get(path string) (string, error) {
 	return string(all), nil
 }
 
+func (c *Client) setQuicConfig(config *quic.Config) {
+	c.client.Transport.(*http3.RoundTripper).QuicConfig = config
+}
+
 func (c *Client) CloseIdleConnections() {
 	c.client.CloseIdleConnections()
 }
@@ -4,6 +4,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"github.com/chuccp/kuic/cert"
+	"github.com/quic-go/quic-go"
 	"github.com/quic-go/quic-go/http3"
 	"net"
 	"net/http"
@@ -53,6 +54,10 @@ func NewTslReverseProxy(remoteAddress *net.UDPAddr, conn net.PacketConn, cert *c
 	return &ReverseProxy{reverseProxy: reverseProxy, remoteUrl: parseUrl}, nil
 }
 
+func (p *ReverseProxy) setQuicConfig(config *quic.Config) {
+	p.reverseProxy.Transport.(*http3.RoundTripper).QuicConfig = config
+}
+
 func (p *ReverseProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	p.reverseProxy.ServeHTTP(rw, req)
 }
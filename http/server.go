@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"github.com/chuccp/kuic"
 	"github.com/chuccp/kuic/cert"
+	"github.com/quic-go/quic-go"
 	"github.com/quic-go/quic-go/http3"
 	"net"
 	"net/http"
@@ -14,6 +15,7 @@ type Server struct {
 	addr       string
 	baseServer kuic.BaseServer
 	clientPool *ClientPool
+	config     *kuic.Config
 }
 
 func (server *Server) GetHttpClient(address *net.UDPAddr) (*Client, error) {
@@ -36,19 +38,17 @@ func (server *Server) ListenAndServeTLS(certFile, keyFile string, handler http.H
 	if err != nil {
 		return err
 	}
-	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	config, err := server.tlsConfig(certFile, keyFile)
 	if err != nil {
 		return err
 	}
-	config := &tls.Config{
-		Certificates: []tls.Certificate{cert},
-	}
 	if handler == nil {
 		handler = http.DefaultServeMux
 	}
 	quicServer := &http3.Server{
-		TLSConfig: config,
-		Handler:   handler,
+		TLSConfig:  config,
+		Handler:    handler,
+		QuicConfig: server.quicConfig(),
 	}
 	hErr := make(chan error)
 	qErr := make(chan error)
@@ -75,19 +75,17 @@ func (server *Server) ListenAndServe(certFile, keyFile string, handler http.Hand
 	if err != nil {
 		return err
 	}
-	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	config, err := server.tlsConfig(certFile, keyFile)
 	if err != nil {
 		return err
 	}
-	config := &tls.Config{
-		Certificates: []tls.Certificate{cert},
-	}
 	if handler == nil {
 		handler = http.DefaultServeMux
 	}
 	quicServer := &http3.Server{
-		TLSConfig: config,
-		Handler:   handler,
+		TLSConfig:  config,
+		Handler:    handler,
+		QuicConfig: server.quicConfig(),
 	}
 	hErr := make(chan error)
 	qErr := make(chan error)
@@ -119,8 +117,9 @@ func (server *Server) ListenAndServeWithTls(tlsConfig *tls.Config, handler http.
 		handler = http.DefaultServeMux
 	}
 	quicServer := &http3.Server{
-		TLSConfig: tlsConfig,
-		Handler:   handler,
+		TLSConfig:  tlsConfig,
+		Handler:    handler,
+		QuicConfig: server.quicConfig(),
 	}
 	hErr := make(chan error)
 	qErr := make(chan error)
@@ -156,8 +155,9 @@ func (server *Server) ListenAndServeWithKuicTls(manager *cert.Manager, handler h
 		ClientAuth:   tls.VerifyClientCertIfGiven,
 	}
 	quicServer := &http3.Server{
-		TLSConfig: tlsConfig,
-		Handler:   handler,
+		TLSConfig:  tlsConfig,
+		Handler:    handler,
+		QuicConfig: server.quicConfig(),
 	}
 
 	hErr := make(chan error)
@@ -180,8 +180,46 @@ func (server *Server) ListenAndServeWithKuicTls(manager *cert.Manager, handler h
 	return nil
 }
 
+// ServeListener serves HTTP/3 with handler on the connections of listener
+// that negotiate h3, while its kuic connections are still accepted by
+// listener.Accept. Closing the returned server leaves the listener open.
+func ServeListener(listener *kuic.Listener, handler http.Handler) *http3.Server {
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	quicServer := &http3.Server{Handler: handler}
+	listener.Handle(http3.NextProtoH3, func(conn quic.Connection) {
+		quicServer.ServeQUICConn(conn)
+	})
+	return quicServer
+}
+
+// tlsConfig is the TLS config of the server's kuic.Config if it has one, or
+// else the certificate in certFile and keyFile.
+func (server *Server) tlsConfig(certFile, keyFile string) (*tls.Config, error) {
+	if server.config != nil && server.config.TLSConfig != nil {
+		return server.config.ServerTLSConfig(), nil
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
+
+func (server *Server) quicConfig() *quic.Config {
+	return server.config.TransportConfig()
+}
+
 func CreateServer(addr string) (*Server, error) {
-	server := &Server{addr: addr}
+	return CreateServerWithConfig(addr, nil)
+}
+
+// CreateServerWithConfig is CreateServer with the TLS and QUIC settings of
+// config, which take precedence over the certificate files passed to the
+// ListenAndServe methods.
+func CreateServerWithConfig(addr string, config *kuic.Config) (*Server, error) {
+	server := &Server{addr: addr, config: config}
 	udpAddr, err := net.ResolveUDPAddr("udp", addr)
 	if err != nil {
 		return nil, err
@@ -190,7 +228,7 @@ func CreateServer(addr string) (*Server, error) {
 	if err != nil {
 		return nil, err
 	}
-	server.baseServer = kuic.NewBaseServer(udpConn, context.Background())
-	server.clientPool = NewClientPool(server.baseServer)
+	server.baseServer = kuic.NewBaseServerWithConfig(udpConn, context.Background(), config)
+	server.clientPool = NewClientPoolWithConfig(server.baseServer, config)
 	return server, nil
 }
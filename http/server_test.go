@@ -1,6 +1,8 @@
 package http
 
 import (
+	"crypto/tls"
+	"github.com/chuccp/kuic"
 	"github.com/chuccp/kuic/cert"
 	"log"
 	"net"
@@ -75,3 +77,18 @@ func TestServerAAA(t *testing.T) {
 	}
 
 }
+
+func TestServerConfig(t *testing.T) {
+	tlsConfig := &tls.Config{ServerName: "kuic-test"}
+	server, err := CreateServerWithConfig("127.0.0.1:0", &kuic.Config{TLSConfig: tlsConfig})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := server.tlsConfig("missing.pem", "missing.key")
+	if err != nil || config.ServerName != tlsConfig.ServerName {
+		t.Fatalf("server ignored the TLS config of its kuic.Config: %v", err)
+	}
+	if !server.quicConfig().EnableDatagrams {
+		t.Fatal("server does not negotiate datagrams")
+	}
+}
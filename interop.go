@@ -0,0 +1,206 @@
+package kuic
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// interopIdleTimeout is how long a stock QUIC peer is remembered after its
+// last packet.
+const interopIdleTimeout = 2 * time.Minute
+
+const (
+	quicVersion1       uint32 = 0x00000001
+	quicVersion2       uint32 = 0x6b3343cf
+	quicVersionDraft29 uint32 = 0xff00001d
+)
+
+// interop recognises QUIC packets sent by stock clients, which carry no kuic
+// header. Long header packets are recognised by walking their Length fields
+// to the exact end of the datagram; a kuic header left behind never parses
+// as another packet. Short header packets carry no length, so they are
+// recognised by their sender's address or by a connection ID the server
+// handed out in one of its long header packets.
+type interop struct {
+	enabled   atomic.Bool
+	peers     map[string]time.Time
+	cids      map[string]time.Time
+	cidLen    int
+	lastSweep time.Time
+	locker    *sync.Mutex
+}
+
+func newInterop() *interop {
+	return &interop{peers: make(map[string]time.Time), cids: make(map[string]time.Time), locker: new(sync.Mutex)}
+}
+
+func readVarint(data []byte) (uint64, int, bool) {
+	if len(data) == 0 {
+		return 0, 0, false
+	}
+	n := 1 << (data[0] >> 6)
+	if len(data) < n {
+		return 0, 0, false
+	}
+	v := uint64(data[0] & 0x3F)
+	for _, b := range data[1:n] {
+		v = v<<8 | uint64(b)
+	}
+	return v, n, true
+}
+
+// longHeader is the part of a long header packet interop cares about.
+type longHeader struct {
+	initial bool
+	retry   bool
+	dcid    []byte
+	scid    []byte
+	length  int
+}
+
+func parseLongHeader(data []byte) (*longHeader, bool) {
+	if len(data) < 7 || data[0]&0xC0 != 0xC0 {
+		return nil, false
+	}
+	version := binary.BigEndian.Uint32(data[1:5])
+	typ := data[0] >> 4 & 0x3
+	h := &longHeader{}
+	switch version {
+	case quicVersion1, quicVersionDraft29:
+		h.initial, h.retry = typ == 0, typ == 3
+	case quicVersion2:
+		h.initial, h.retry = typ == 1, typ == 0
+	default:
+		return nil, false
+	}
+	pos := 5
+	dcidLen := int(data[pos])
+	if dcidLen > 20 || len(data) < pos+1+dcidLen+1 {
+		return nil, false
+	}
+	h.dcid = data[pos+1 : pos+1+dcidLen]
+	pos += 1 + dcidLen
+	scidLen := int(data[pos])
+	if scidLen > 20 || len(data) < pos+1+scidLen {
+		return nil, false
+	}
+	h.scid = data[pos+1 : pos+1+scidLen]
+	pos += 1 + scidLen
+	if h.retry {
+		h.length = len(data)
+		return h, true
+	}
+	if h.initial {
+		tokenLen, n, ok := readVarint(data[pos:])
+		if !ok || uint64(len(data)-pos-n) < tokenLen {
+			return nil, false
+		}
+		pos += n + int(tokenLen)
+	}
+	length, n, ok := readVarint(data[pos:])
+	if !ok || uint64(len(data)-pos-n) < length {
+		return nil, false
+	}
+	h.length = pos + n + int(length)
+	return h, true
+}
+
+// isLongHeaderQuic reports whether data is nothing but coalesced QUIC packets
+// a client may send, optionally followed by a short header packet.
+func isLongHeaderQuic(data []byte) bool {
+	first, ok := parseLongHeader(data)
+	if !ok || first.retry || (first.initial && len(data) < quicPacketSize) {
+		return false
+	}
+	for len(data) > 0 {
+		if data[0]&0x80 == 0 {
+			return data[0]&0x40 != 0 && len(data) > 1+len(first.dcid) && string(data[1:1+len(first.dcid)]) == string(first.dcid)
+		}
+		h, ok := parseLongHeader(data)
+		if !ok || h.retry || string(h.dcid) != string(first.dcid) {
+			return false
+		}
+		data = data[h.length:]
+	}
+	return true
+}
+
+// isPlain reports whether data, received from addr, is a QUIC packet of a
+// stock client.
+func (i *interop) isPlain(data []byte, addr net.Addr) bool {
+	if !i.enabled.Load() || len(data) == 0 || data[0]&0x40 == 0 {
+		return false
+	}
+	// a kuic packet may end in a short header packet too
+	if _, _, ok := parseHeader(data); ok {
+		return false
+	}
+	if data[0]&0x80 != 0 {
+		if !isLongHeaderQuic(data) {
+			return false
+		}
+		i.seen(addr)
+		return true
+	}
+	now := time.Now()
+	i.locker.Lock()
+	defer i.locker.Unlock()
+	key := addr.String()
+	if lastSeen, ok := i.peers[key]; ok && now.Sub(lastSeen) < interopIdleTimeout {
+		i.peers[key] = now
+		return true
+	}
+	if i.cidLen == 0 || len(data) <= 1+i.cidLen {
+		return false
+	}
+	cid := string(data[1 : 1+i.cidLen])
+	if lastSeen, ok := i.cids[cid]; ok && now.Sub(lastSeen) < interopIdleTimeout {
+		// the client moved to a new address
+		i.cids[cid] = now
+		i.peers[key] = now
+		return true
+	}
+	return false
+}
+
+func (i *interop) seen(addr net.Addr) {
+	now := time.Now()
+	i.locker.Lock()
+	defer i.locker.Unlock()
+	i.peers[addr.String()] = now
+	i.sweep(now)
+}
+
+// sent remembers the connection ID the server chose, carried as the source
+// connection ID of its long header packets.
+func (i *interop) sent(data []byte, addr net.Addr) {
+	now := time.Now()
+	i.locker.Lock()
+	defer i.locker.Unlock()
+	i.peers[addr.String()] = now
+	if h, ok := parseLongHeader(data); ok && len(h.scid) > 0 {
+		i.cidLen = len(h.scid)
+		i.cids[string(h.scid)] = now
+	}
+	i.sweep(now)
+}
+
+func (i *interop) sweep(now time.Time) {
+	if now.Sub(i.lastSweep) < interopIdleTimeout {
+		return
+	}
+	i.lastSweep = now
+	for key, lastSeen := range i.peers {
+		if now.Sub(lastSeen) >= interopIdleTimeout {
+			delete(i.peers, key)
+		}
+	}
+	for cid, lastSeen := range i.cids {
+		if now.Sub(lastSeen) >= interopIdleTimeout {
+			delete(i.cids, cid)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package kuic
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/quic-go/quic-go"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestIsLongHeaderQuic(t *testing.T) {
+	length := quicPacketSize - 14
+	initial := []byte{0xC0, 0, 0, 0, 1, 4, 1, 2, 3, 4, 0, 0, 0x40 | byte(length>>8), byte(length)}
+	initial = append(initial, make([]byte, length)...)
+	if !isLongHeaderQuic(initial) {
+		t.Fatal("initial packet not recognised")
+	}
+	if isLongHeaderQuic(appendHeader(initial, header{seq: 1})) {
+		t.Fatal("kuic packet taken for a stock one")
+	}
+	if isLongHeaderQuic(initial[:1000]) {
+		t.Fatal("truncated initial packet taken for a stock one")
+	}
+	short := append(initial, 0x40, 1, 2, 3, 4, 0xAA)
+	if !isLongHeaderQuic(short) {
+		t.Fatal("coalesced short header packet not recognised")
+	}
+	interop := newInterop()
+	interop.enabled.Store(true)
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+	if interop.isPlain(appendHeader(short, header{seq: 1}), addr) {
+		t.Fatal("kuic packet ending in a short header packet taken for a stock one")
+	}
+	if !interop.isPlain(short, addr) {
+		t.Fatal("coalesced short header packet not taken for a stock one")
+	}
+}
+
+func TestInterop(t *testing.T) {
+	server, err := ListenConfig(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, &Config{Interop: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	go func() {
+		for {
+			conn, err := server.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				stream, err := conn.AcceptStream(context.Background())
+				if err != nil {
+					return
+				}
+				io.Copy(stream, stream)
+				stream.Close()
+			}()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stock, err := quic.DialAddr(ctx, server.Addr().String(), &tls.Config{InsecureSkipVerify: true, NextProtos: []string{NextProto}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stock.CloseWithError(0, "")
+	if err := echo(createConnection(stock)); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := listenLocal(t).Dial(server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := echo(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	closed := listenLocal(t)
+	_, err = quic.DialAddr(ctx, closed.Addr().String(), &tls.Config{InsecureSkipVerify: true, NextProtos: []string{NextProto}}, &quic.Config{HandshakeIdleTimeout: 300 * time.Millisecond})
+	if err == nil {
+		t.Fatal("stock client connected without interop")
+	}
+	if closed.RejectedPackets() == 0 {
+		t.Fatal("stock packets were not rejected")
+	}
+}
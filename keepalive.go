@@ -0,0 +1,145 @@
+package kuic
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultKeepaliveInterval stays below the shortest UDP binding timeout
+// commonly found in NATs (30s).
+const DefaultKeepaliveInterval = 25 * time.Second
+
+type KeepalivePeer struct {
+	Addr     *net.UDPAddr
+	LastSeen time.Time
+	LastSent time.Time
+}
+
+type keepalive struct {
+	interval time.Duration
+	peers    map[string]*KeepalivePeer
+	count    atomic.Int32
+	reset    chan struct{}
+	locker   *sync.Mutex
+}
+
+func newKeepalive() *keepalive {
+	return &keepalive{interval: DefaultKeepaliveInterval, peers: make(map[string]*KeepalivePeer), reset: make(chan struct{}, 1), locker: new(sync.Mutex)}
+}
+
+func (k *keepalive) add(addr *net.UDPAddr) {
+	k.locker.Lock()
+	defer k.locker.Unlock()
+	if _, ok := k.peers[addr.String()]; !ok {
+		k.peers[addr.String()] = &KeepalivePeer{Addr: addr}
+		k.count.Add(1)
+	}
+}
+
+func (k *keepalive) remove(addr *net.UDPAddr) {
+	k.locker.Lock()
+	defer k.locker.Unlock()
+	if _, ok := k.peers[addr.String()]; ok {
+		delete(k.peers, addr.String())
+		k.count.Add(-1)
+	}
+}
+
+func (k *keepalive) setInterval(interval time.Duration) {
+	k.locker.Lock()
+	k.interval = interval
+	k.locker.Unlock()
+	select {
+	case k.reset <- struct{}{}:
+	default:
+	}
+}
+
+func (k *keepalive) getInterval() time.Duration {
+	k.locker.Lock()
+	defer k.locker.Unlock()
+	return k.interval
+}
+
+// seen is called for every packet run reads, so it stays cheap while no
+// peer is registered.
+func (k *keepalive) seen(addr net.Addr) {
+	if k.count.Load() == 0 {
+		return
+	}
+	k.locker.Lock()
+	defer k.locker.Unlock()
+	if peer, ok := k.peers[addr.String()]; ok {
+		peer.LastSeen = time.Now()
+	}
+}
+
+func (k *keepalive) due() []*net.UDPAddr {
+	k.locker.Lock()
+	defer k.locker.Unlock()
+	addrs := make([]*net.UDPAddr, 0, len(k.peers))
+	now := time.Now()
+	for _, peer := range k.peers {
+		peer.LastSent = now
+		addrs = append(addrs, peer.Addr)
+	}
+	return addrs
+}
+
+func (k *keepalive) list() []*KeepalivePeer {
+	k.locker.Lock()
+	defer k.locker.Unlock()
+	peers := make([]*KeepalivePeer, 0, len(k.peers))
+	for _, peer := range k.peers {
+		p := *peer
+		peers = append(peers, &p)
+	}
+	return peers
+}
+
+func (bs *baseServer) runKeepalive() {
+	for {
+		var timer *time.Timer
+		var tick <-chan time.Time
+		if interval := bs.keepalive.getInterval(); interval > 0 {
+			timer = time.NewTimer(interval)
+			tick = timer.C
+		}
+		select {
+		case <-tick:
+			for _, addr := range bs.keepalive.due() {
+				bs.writeControl(controlKeepalive, nil, addr)
+			}
+		case <-bs.keepalive.reset:
+		case <-bs.context.Done():
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if bs.context.Err() != nil {
+			return
+		}
+	}
+}
+
+// AddKeepalive keeps the NAT binding towards addr open by sending it a
+// no-op packet every keepalive interval, typically a peer or a rendezvous
+// server.
+func (l *Listener) AddKeepalive(addr *net.UDPAddr) {
+	l.baseServer.keepalive.add(addr)
+}
+
+func (l *Listener) RemoveKeepalive(addr *net.UDPAddr) {
+	l.baseServer.keepalive.remove(addr)
+}
+
+// SetKeepaliveInterval changes the keepalive interval, zero stops sending.
+func (l *Listener) SetKeepaliveInterval(interval time.Duration) {
+	l.baseServer.keepalive.setInterval(interval)
+}
+
+func (l *Listener) KeepalivePeers() []*KeepalivePeer {
+	return l.baseServer.keepalive.list()
+}
@@ -0,0 +1,59 @@
+package kuic
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestKeepalive(t *testing.T) {
+	a := listenLocal(t)
+	b := listenLocal(t)
+	a.SetKeepaliveInterval(20 * time.Millisecond)
+	b.SetKeepaliveInterval(20 * time.Millisecond)
+	a.AddKeepalive(b.Addr())
+	b.AddKeepalive(a.Addr())
+
+	go func() {
+		conn, err := b.Accept()
+		if err != nil {
+			return
+		}
+		stream, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		io.Copy(stream, stream)
+		stream.Close()
+	}()
+	conn, err := a.Dial(b.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	peers := a.KeepalivePeers()
+	if len(peers) != 1 || peers[0].LastSent.IsZero() || peers[0].LastSeen.IsZero() {
+		t.Fatalf("unexpected keepalive state %+v", peers)
+	}
+	stream, err := conn.OpenStreamSync(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Write([]byte("alive"))
+	stream.Close()
+	data, err := io.ReadAll(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "alive" {
+		t.Fatalf("unexpected echo %q", data)
+	}
+
+	a.RemoveKeepalive(b.Addr())
+	if peers := a.KeepalivePeers(); len(peers) != 0 {
+		t.Fatalf("peer not removed: %+v", peers)
+	}
+}
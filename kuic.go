@@ -1,169 +1,195 @@
 package kuic
 
 import (
-	"container/list"
 	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
-	"errors"
+	"github.com/chuccp/kuic/cert"
 	"github.com/quic-go/quic-go"
 	"math/big"
 	"net"
 	"sync"
+	"sync/atomic"
 )
 
-type seqStack struct {
-	l      *list.List
-	locker *sync.Mutex
-}
-
-var ErrConnNumOver = errors.New("conn number Over")
-
-func (s *seqStack) init() {
-	num := int(MaxSeqNum)
-	for i := 0; i <= num; i++ {
-		s.l.PushBack(uint16(i))
-	}
-}
-
-func (s *seqStack) pop() (uint16, error) {
-	s.locker.Lock()
-	defer s.locker.Unlock()
-	if s.l.Len() == 0 {
-		return 0, ErrConnNumOver
-	}
-
-	ele := s.l.Front()
-	s.l.Remove(ele)
-	return ele.Value.(uint16), nil
+type BaseServer interface {
+	GetServerConn() (*BasicConn, error)
+	GetServiceConn(id ServiceID) (*BasicConn, error)
+	GetClientConn() (*BasicConn, error)
 }
 
-func (s *seqStack) push(seq uint16) {
-	s.locker.Lock()
-	defer s.locker.Unlock()
-	s.l.PushBack(seq)
+type baseServer struct {
+	sockets     []*socket
+	routes      *routes
+	registry    *registry
+	seqStack    *seqStack
+	context     context.Context
+	listener    *quic.Listener
+	locker      *sync.Mutex
+	puncher     *puncher
+	stunClient  *stunClient
+	relayServer atomic.Pointer[relayServer]
+	relayClient *relayClient
+	keepalive   *keepalive
+	framing     *framing
+	interop     *interop
+	protocols   *protocols
+	config      *Config
 }
 
-func newSeqStack() *seqStack {
-	seqStack := &seqStack{l: new(list.List), locker: new(sync.Mutex)}
-	seqStack.init()
-	return seqStack
+// NewBaseServer runs kuic over conn, usually a *net.UDPConn. Any other
+// net.PacketConn works too; one that has ReadBatch and WriteBatch methods
+// like ipv4.PacketConn is read and written in batches.
+func NewBaseServer(conn net.PacketConn, context context.Context) *baseServer {
+	return NewBaseServerWithConfig(conn, context, nil)
 }
 
-type BaseServer interface {
-	GetServerConn() (*BasicConn, error)
-	GetClientConn() (*BasicConn, error)
+// NewBaseServerWithConfig is NewBaseServer for servers that need the framing
+// options of config; its TLS and QUIC settings are left to the caller.
+func NewBaseServerWithConfig(conn net.PacketConn, context context.Context, config *Config) *baseServer {
+	return newBaseServer([]net.PacketConn{conn}, context, config)
 }
 
-type baseServer struct {
-	udpConn      *net.UDPConn
-	basicConnMap map[uint16]*BasicConn
-	serverConn   *BasicConn
-	seqStack     *seqStack
-	context      context.Context
-	listener     *quic.Listener
-	locker       *sync.Mutex
-}
-
-func NewBaseServer(udpConn *net.UDPConn, context context.Context) *baseServer {
-	baseServer := &baseServer{udpConn: udpConn, basicConnMap: make(map[uint16]*BasicConn), seqStack: newSeqStack(), context: context, locker: new(sync.Mutex)}
-	go baseServer.run()
+// newBaseServer serves the same conns and sessions on all of conns; the
+// first one is the address the listener is known by.
+func newBaseServer(conns []net.PacketConn, context context.Context, config *Config) *baseServer {
+	baseServer := &baseServer{routes: newRoutes(), registry: new(registry), seqStack: newSeqStack(), context: context, locker: new(sync.Mutex), puncher: newPuncher(), relayClient: newRelayClient(), keepalive: newKeepalive(), framing: newFraming(), interop: newInterop(), protocols: newProtocols(), config: config}
+	baseServer.seqStack.quarantine = config.seqQuarantine()
+	baseServer.framing.acceptLegacy.Store(config != nil && config.LegacyFraming)
+	baseServer.interop.enabled.Store(config != nil && config.Interop)
+	baseServer.stunClient = newStunClient(baseServer.writeRaw)
+	for _, conn := range conns {
+		baseServer.sockets = append(baseServer.sockets, newSocket(conn))
+	}
+	for _, socket := range baseServer.sockets {
+		go baseServer.run(socket)
+		go socket.sender.run(context.Done())
+	}
+	go baseServer.runKeepalive()
 	return baseServer
 }
-func (bs *baseServer) getBasicConn(seq uint16, addr net.Addr) (*BasicConn, net.Addr, bool) {
-	isServer := seq&0x8000 == 0
+
+// getBasicConn finds the conn h is addressed to, and counts the packet as
+// rejected or stale if there is none.
+func (bs *baseServer) getBasicConn(h header, addr net.Addr) (*BasicConn, Addr, bool) {
+	isServer := h.seq&0x8000 == 0
 	if isServer {
-		return bs.serverConn, NewAddr(addr, seq|0x8000), bs.serverConn != nil
-	} else {
-		bc, ok := bs.basicConnMap[seq]
-		return bc, NewAddr(addr, seq), ok
-	}
-}
-func (bs *baseServer) run() {
-	for {
-		data := make([]byte, MaxPacketBufferSize)
-		to, addr, err := bs.udpConn.ReadFrom(data)
-		if err != nil {
-			return
-		} else {
-			dataLen := to - 2
-			seq := uint16(data[dataLen])<<8 | uint16(data[dataLen+1])
-			bb, rAddr, ok := bs.getBasicConn(seq, addr)
-			if ok {
-				bb.handlePacket(&packet{num: dataLen, addr: rAddr, err: err, data: data})
-			}
+		bc := bs.registry.services[h.service].Load()
+		if bc == nil {
+			bs.framing.rejected.Add(1)
+			return nil, Addr{}, false
 		}
+		return bc, Addr{Addr: addr, seq: h.seq | 0x8000, gen: h.gen, service: h.service}, true
+	}
+	bc, ok := bs.registry.client(h.seq)
+	if !ok {
+		bs.framing.rejected.Add(1)
+		return nil, Addr{}, false
+	}
+	if gen := bc.lAddr.(*Addr).gen; h.gen != 0 && h.gen != gen {
+		bs.seqStack.stale.Add(1)
+		return nil, Addr{}, false
 	}
+	return bc, Addr{Addr: addr, seq: h.seq}, true
 }
-
 func (bs *baseServer) WriteTo(ps []byte, addr net.Addr) (n int, err error) {
+	return bs.writeTo(ps, addr, nil)
+}
+
+// writeTo is WriteTo for a conn whose write deadline closes timeout.
+func (bs *baseServer) writeTo(ps []byte, addr net.Addr, timeout <-chan struct{}) (n int, err error) {
 	a := addr.(*Addr)
-	seq := a.seq
-	data := append(ps, byte(seq>>8), byte(seq))
-	return bs.udpConn.WriteTo(data, a.Addr)
+	if a.plain {
+		bs.interop.sent(ps, a.Addr)
+	}
+	if relayAddr, ok := a.Addr.(*RelayAddr); ok {
+		return bs.writeRelay(ps, a, relayAddr)
+	}
+	return bs.writePacket(ps, a, timeout)
 }
 func (bs *baseServer) GetServerConn() (*BasicConn, error) {
-	bs.locker.Lock()
-	defer bs.locker.Unlock()
-	if bs.serverConn != nil {
-		return bs.serverConn, errors.New(" only can get once")
-	}
-	bc := NewBasicConn(bs.udpConn, bs.WriteTo, NewAddr(bs.udpConn.LocalAddr(), 0), bs.context)
-	bs.serverConn = bc
-	return bc, nil
+	return bs.GetServiceConn(DefaultService)
+}
+
+// newBasicConn makes a conn on socket, whose address it reports and whose
+// buffers it sizes.
+func (bs *baseServer) newBasicConn(socket *socket, lSeq uint16, gen byte, service ServiceID) *BasicConn {
+	conn := socket.conn
+	return newBasicConn(conn, bs.writeTo, &Addr{Addr: conn.LocalAddr(), seq: lSeq, gen: gen, service: service}, bs.context, bs.config.receiveQueueSize(), bs.config.dropPolicy())
 }
+
+// close closes the QUIC listener and stops reading and writing the
+// sockets.
 func (bs *baseServer) close() error {
-	return bs.listener.Close()
+	err := bs.listener.Close()
+	for _, socket := range bs.sockets {
+		socket.stop()
+	}
+	return err
 }
 
 func (bs *baseServer) accept() (Connection, error) {
-	conn, err := bs.listener.Accept(bs.context)
-	if err != nil {
-		return nil, err
+	select {
+	case conn := <-bs.protocols.accepted:
+		return createConnection(conn), nil
+	case <-bs.protocols.done:
+		return nil, bs.protocols.err
 	}
-	return createConnection(conn, bs.context), nil
 }
 
-func (bs *baseServer) dial(rAddr *net.UDPAddr) (Connection, error) {
-	seq, err := bs.seqStack.pop()
+func (bs *baseServer) dial(rAddr net.Addr) (Connection, error) {
+	return bs.dialConfig(rAddr, bs.config)
+}
+
+func (bs *baseServer) dialConfig(rAddr net.Addr, config *Config) (Connection, error) {
+	return bs.dialService(rAddr, DefaultService, config)
+}
+
+func (bs *baseServer) dialService(rAddr net.Addr, service ServiceID, config *Config) (Connection, error) {
+	return bs.dialContext(bs.context, rAddr, service, config)
+}
+
+// dialContext dials rAddr, giving up the handshake and the seq once ctx is
+// done.
+func (bs *baseServer) dialContext(ctx context.Context, rAddr net.Addr, service ServiceID, config *Config) (Connection, error) {
+	seq, gen, err := bs.seqStack.pop()
 	if err != nil {
 		return nil, err
 	}
 	lSeq := seq | 0x8000
-	clientConn := NewBasicConn(bs.udpConn, bs.WriteTo, NewAddr(bs.udpConn.LocalAddr(), lSeq), bs.context)
+	clientConn := bs.newBasicConn(bs.socketFor(rAddr), lSeq, gen, service)
 	clientConn.isClient = true
-	bs.basicConnMap[lSeq] = clientConn
-	tlsConf := &tls.Config{
-		InsecureSkipVerify: true,
-		NextProtos:         []string{"kuic"},
-	}
-	conn, err := quic.Dial(bs.context, clientConn, NewAddr(rAddr, seq), tlsConf, nil)
+	bs.registry.add(lSeq, clientConn)
+	conn, err := quic.Dial(ctx, clientConn, &Addr{Addr: rAddr, seq: seq, gen: gen, service: service}, config.clientTLSConfig(), config.TransportConfig())
 	if err != nil {
+		clientConn.Close()
+		bs.registry.remove(lSeq)
 		bs.seqStack.push(seq)
 		return nil, err
 	}
 	go func() {
 		<-conn.Context().Done()
-		delete(bs.basicConnMap, seq)
+		clientConn.Close()
+		bs.registry.remove(lSeq)
 		bs.seqStack.push(seq)
 	}()
-	return createConnection(conn, bs.context), nil
+	return createConnection(conn), nil
 }
 func (bs *baseServer) GetClientConn() (*BasicConn, error) {
-	seq, err := bs.seqStack.pop()
+	seq, gen, err := bs.seqStack.pop()
 	if err != nil {
 		return nil, err
 	}
 	lSeq := seq | 0x8000
-	clientConn := NewBasicConn(bs.udpConn, bs.WriteTo, NewAddr(bs.udpConn.LocalAddr(), lSeq), bs.context)
+	clientConn := bs.newBasicConn(bs.sockets[0], lSeq, gen, DefaultService)
 	clientConn.isClient = true
-	bs.basicConnMap[lSeq] = clientConn
+	bs.registry.add(lSeq, clientConn)
 	go func() {
 		clientConn.WaitClose()
-		delete(bs.basicConnMap, seq)
+		bs.registry.remove(lSeq)
 		bs.seqStack.push(seq)
 	}()
 	return clientConn, nil
@@ -173,6 +199,8 @@ type Listener struct {
 	baseServer *baseServer
 	context    context.Context
 	cancelFunc context.CancelFunc
+	// owned are the sockets the listener opened itself, and closes.
+	owned []net.PacketConn
 }
 
 func generateTLSConfig() *tls.Config {
@@ -194,20 +222,57 @@ func generateTLSConfig() *tls.Config {
 	}
 	return &tls.Config{
 		Certificates: []tls.Certificate{tlsCert},
-		NextProtos:   []string{"kuic"},
+		NextProtos:   []string{NextProto},
 	}
 }
 
+// Accept returns the next connection that no Handle handler took. See Handle
+// for how many connections wait for it.
 func (l *Listener) Accept() (Connection, error) {
 	return l.baseServer.accept()
 }
 
+// Dial dials addr with the Config the listener was created with.
 func (l *Listener) Dial(addr *net.UDPAddr) (Connection, error) {
 	return l.baseServer.dial(addr)
 }
+
+// DialWithCert dials addr presenting a kuic client certificate and verifies
+// the server against the certificate's CA.
+func (l *Listener) DialWithCert(addr *net.UDPAddr, certificate *cert.Certificate) (Connection, error) {
+	config := l.baseServer.config.Clone()
+	config.TLSClientConfig = certificateTLSConfig(certificate)
+	return l.baseServer.dialConfig(addr, config)
+}
+
+// DialWithConfig dials addr with config instead of the one the listener was
+// created with.
+func (l *Listener) DialWithConfig(addr *net.UDPAddr, config *Config) (Connection, error) {
+	return l.baseServer.dialConfig(addr, config)
+}
+
+// Addr is the local address of the listener, nil if its transport does not
+// use UDP addresses.
+func (l *Listener) Addr() *net.UDPAddr {
+	addr, _ := l.baseServer.sockets[0].conn.LocalAddr().(*net.UDPAddr)
+	return addr
+}
+
+// Addrs are the local addresses of all sockets of the listener.
+func (l *Listener) Addrs() []*net.UDPAddr {
+	var addrs []*net.UDPAddr
+	for _, socket := range l.baseServer.sockets {
+		if addr, ok := socket.conn.LocalAddr().(*net.UDPAddr); ok {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
 func (l *Listener) Close() error {
 	l.cancelFunc()
-	return l.baseServer.close()
+	err := l.baseServer.close()
+	closeAll(l.owned)
+	return err
 }
 
 func (l *Listener) GetServerConn() (net.PacketConn, error) {
@@ -217,21 +282,59 @@ func (l *Listener) GetClientConn() (net.PacketConn, error) {
 	return l.baseServer.GetClientConn()
 }
 func Listen(addr *net.UDPAddr) (*Listener, error) {
+	return ListenConfig(addr, nil)
+}
+
+// ListenConfig is Listen with the TLS and QUIC settings of config.
+func ListenConfig(addr *net.UDPAddr, config *Config) (*Listener, error) {
 	udpConn, err := net.ListenUDP("udp", addr)
 	if err != nil {
 		return nil, err
 	}
+	return listenPackets([]net.PacketConn{udpConn}, true, config)
+}
+
+// ListenPacket is Listen over a transport of the caller's, which stays
+// open when the listener is closed and can be read from again.
+func ListenPacket(packetConn net.PacketConn, config *Config) (*Listener, error) {
+	return listenPackets([]net.PacketConn{packetConn}, false, config)
+}
+
+func listenPackets(conns []net.PacketConn, owned bool, config *Config) (*Listener, error) {
+	listener, err := newListener(conns, config)
+	if err != nil {
+		if owned {
+			closeAll(conns)
+		}
+		return nil, err
+	}
+	if owned {
+		listener.owned = conns
+	}
+	return listener, nil
+}
+
+func newListener(conns []net.PacketConn, config *Config) (*Listener, error) {
 	context, contextCancelFunc := context.WithCancel(context.Background())
-	baseServer := NewBaseServer(udpConn, context)
+	baseServer := newBaseServer(conns, context, config)
 	conn, err := baseServer.GetServerConn()
 	if err != nil {
+		contextCancelFunc()
 		return nil, err
 	}
-	listen, err := quic.Listen(conn, generateTLSConfig(), nil)
+	listen, err := quic.Listen(conn, baseServer.protocols.tlsConfig(config.ServerTLSConfig()), config.TransportConfig())
 	if err != nil {
+		contextCancelFunc()
 		return nil, err
 	}
 	baseServer.listener = listen
-	listener := &Listener{baseServer, context, contextCancelFunc}
+	go baseServer.dispatch()
+	listener := &Listener{baseServer: baseServer, context: context, cancelFunc: contextCancelFunc}
 	return listener, nil
 }
+
+// ListenWithManager listens with the server certificate of manager and
+// requires clients to present a certificate signed by its client CA.
+func ListenWithManager(addr *net.UDPAddr, manager *cert.Manager) (*Listener, error) {
+	return ListenConfig(addr, &Config{TLSConfig: managerTLSConfig(manager)})
+}
@@ -1,6 +1,7 @@
 package kuic
 
 import (
+	"context"
 	"log"
 	"net"
 	"testing"
@@ -21,7 +22,7 @@ func TestName2(t *testing.T) {
 				t.Log(err)
 				return
 			} else {
-				stream, err := conn.AcceptStream()
+				stream, err := conn.AcceptStream(context.Background())
 				if err != nil {
 					return
 				} else {
@@ -45,7 +46,7 @@ func TestName2(t *testing.T) {
 		return
 	}
 
-	sync, err := dial.OpenStreamSync()
+	sync, err := dial.OpenStreamSync(context.Background())
 	if err != nil {
 		return
 	}
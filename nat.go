@@ -0,0 +1,239 @@
+package kuic
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+)
+
+const (
+	stunAttrChangeRequest uint16 = 0x0003
+	stunAttrOtherAddress  uint16 = 0x802C
+)
+
+const (
+	stunChangeIP   uint32 = 0x04
+	stunChangePort uint32 = 0x02
+)
+
+// natFilterTimeout bounds the filtering tests, where a NAT that filters is
+// expected to swallow the answer and only silence tells us so.
+const natFilterTimeout = 3 * time.Second
+
+var (
+	ErrNoStunServer = errors.New("stun: no server answered")
+	ErrNoUDPAddr    = errors.New("nat: listener has no UDP address")
+)
+
+type NATBehavior int
+
+const (
+	BehaviorUnknown NATBehavior = iota
+	EndpointIndependent
+	AddressDependent
+	AddressAndPortDependent
+)
+
+func (b NATBehavior) String() string {
+	switch b {
+	case EndpointIndependent:
+		return "endpoint-independent"
+	case AddressDependent:
+		return "address-dependent"
+	case AddressAndPortDependent:
+		return "address-and-port-dependent"
+	}
+	return "unknown"
+}
+
+// NATInfo is the outcome of the RFC 5780 behaviour discovery tests.
+type NATInfo struct {
+	LocalAddr  *net.UDPAddr
+	MappedAddr *net.UDPAddr
+	Server     *net.UDPAddr
+	NoNAT      bool
+	Mapping    NATBehavior
+	Filtering  NATBehavior
+}
+
+func (c *stunClient) bindingChange(ctx context.Context, server net.Addr, change uint32) (*stunResponse, *net.UDPAddr, error) {
+	req := newStunMessage(stunBindingRequest)
+	if change != 0 {
+		value := make([]byte, 4)
+		binary.BigEndian.PutUint32(value, change)
+		req.addAttr(stunAttrChangeRequest, value)
+	}
+	resp, err := c.roundTrip(ctx, server, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.msg.typ != stunBindingSuccess {
+		return nil, nil, resp.msg.errorCode()
+	}
+	mapped, err := resp.msg.mappedAddr()
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, mapped, nil
+}
+
+func (c *stunClient) filterTest(ctx context.Context, server *net.UDPAddr, change uint32, timeout time.Duration) (bool, error) {
+	testCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	resp, _, err := c.bindingChange(testCtx, server, change)
+	if err != nil {
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		if errors.Is(err, ErrStunTimeout) {
+			return false, nil
+		}
+		return false, err
+	}
+	return resp.from.String() != server.String(), nil
+}
+
+func sameAddr(a, b *net.UDPAddr) bool {
+	return a.IP.Equal(b.IP) && a.Port == b.Port
+}
+
+func isLocalAddr(local, mapped *net.UDPAddr) bool {
+	if local.Port != mapped.Port {
+		return false
+	}
+	if !local.IP.IsUnspecified() {
+		return local.IP.Equal(mapped.IP)
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(mapped.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// classify runs the mapping and filtering tests of RFC 5780 section 4.3 and
+// 4.4 against the first server that answers. Servers without OTHER-ADDRESS
+// support only let us compare mappings across servers, and leave the
+// filtering behaviour unknown.
+func (c *stunClient) classify(ctx context.Context, local *net.UDPAddr, servers []*net.UDPAddr, timeout time.Duration) (*NATInfo, error) {
+	info := &NATInfo{LocalAddr: local}
+	var primary *stunResponse
+	var lastErr error = ErrNoStunServer
+	rest := servers
+	for len(rest) > 0 && primary == nil {
+		server := rest[0]
+		rest = rest[1:]
+		resp, mapped, err := c.bindingChange(ctx, server, 0)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			lastErr = err
+			continue
+		}
+		primary = resp
+		info.Server = server
+		info.MappedAddr = mapped
+	}
+	if primary == nil {
+		return nil, lastErr
+	}
+	info.NoNAT = isLocalAddr(local, info.MappedAddr)
+
+	var other *net.UDPAddr
+	if value, ok := primary.msg.getAttr(stunAttrOtherAddress); ok {
+		other, _ = primary.msg.decodeAddr(value, false)
+	}
+	if other == nil {
+		for _, server := range rest {
+			_, mapped, err := c.bindingChange(ctx, server, 0)
+			if err != nil {
+				continue
+			}
+			if sameAddr(mapped, info.MappedAddr) {
+				info.Mapping = EndpointIndependent
+			} else if server.IP.Equal(info.Server.IP) {
+				info.Mapping = AddressAndPortDependent
+			} else {
+				// across different IPs we can only tell that the
+				// mapping is at least address-dependent
+				info.Mapping = AddressDependent
+			}
+			break
+		}
+		if info.NoNAT {
+			info.Mapping = EndpointIndependent
+		}
+		return info, nil
+	}
+
+	// The filtering tests go first: the mapping tests send to the
+	// alternate address and would open the filter for its answers.
+	ok, err := c.filterTest(ctx, info.Server, stunChangeIP|stunChangePort, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		info.Filtering = EndpointIndependent
+	} else {
+		ok, err = c.filterTest(ctx, info.Server, stunChangePort, timeout)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			info.Filtering = AddressDependent
+		} else {
+			info.Filtering = AddressAndPortDependent
+		}
+	}
+
+	if info.NoNAT {
+		info.Mapping = EndpointIndependent
+	} else {
+		_, mapped2, err := c.bindingChange(ctx, &net.UDPAddr{IP: other.IP, Port: info.Server.Port}, 0)
+		if err != nil {
+			return nil, err
+		}
+		if sameAddr(mapped2, info.MappedAddr) {
+			info.Mapping = EndpointIndependent
+		} else {
+			_, mapped3, err := c.bindingChange(ctx, other, 0)
+			if err != nil {
+				return nil, err
+			}
+			if sameAddr(mapped3, mapped2) {
+				info.Mapping = AddressDependent
+			} else {
+				info.Mapping = AddressAndPortDependent
+			}
+		}
+	}
+
+	return info, nil
+}
+
+// ClassifyNAT determines the mapping and filtering behaviour of the NAT in
+// front of the listener's socket. The servers must be STUN servers, ideally
+// with RFC 5780 (OTHER-ADDRESS and CHANGE-REQUEST) support.
+func (l *Listener) ClassifyNAT(ctx context.Context, servers ...string) (*NATInfo, error) {
+	local := l.Addr()
+	if local == nil {
+		return nil, ErrNoUDPAddr
+	}
+	var addrs []*net.UDPAddr
+	for _, server := range servers {
+		addr, err := net.ResolveUDPAddr("udp", server)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, addr)
+	}
+	return l.baseServer.stunClient.classify(ctx, local, addrs, natFilterTimeout)
+}
@@ -0,0 +1,205 @@
+package kuic
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"github.com/chuccp/kuic/netsim"
+	"net"
+	"testing"
+	"time"
+)
+
+// stunResponse5780 answers req received on at for a client seen as from,
+// honouring CHANGE-REQUEST against the four addresses ip1/ip2 x port1/port2.
+func stunResponse5780(req *stunMessage, at, from *net.UDPAddr, ip1, ip2 net.IP, port1, port2 int) ([]byte, *net.UDPAddr) {
+	otherIP, otherPort := ip2, port2
+	if at.IP.Equal(ip2) {
+		otherIP = ip1
+	}
+	if at.Port == port2 {
+		otherPort = port1
+	}
+	source := &net.UDPAddr{IP: at.IP, Port: at.Port}
+	if value, ok := req.getAttr(stunAttrChangeRequest); ok && len(value) == 4 {
+		change := binary.BigEndian.Uint32(value)
+		if change&stunChangeIP != 0 {
+			source.IP = otherIP
+		}
+		if change&stunChangePort != 0 {
+			source.Port = otherPort
+		}
+	}
+	resp := &stunMessage{typ: stunBindingSuccess, txID: req.txID}
+	resp.addAttr(stunAttrXorMappedAddress, resp.encodeAddr(from, true))
+	resp.addAttr(stunAttrOtherAddress, resp.encodeAddr(&net.UDPAddr{IP: otherIP, Port: otherPort}, false))
+	return resp.encode(), source
+}
+
+type simNAT struct {
+	mapping   NATBehavior
+	filtering NATBehavior
+	publicIP  net.IP
+	nextPort  int
+	mappings  map[string]int
+	permits   map[string]bool
+	client    *stunClient
+}
+
+func behaviorKey(behavior NATBehavior, addr *net.UDPAddr) string {
+	switch behavior {
+	case AddressDependent:
+		return addr.IP.String()
+	case AddressAndPortDependent:
+		return addr.String()
+	}
+	return ""
+}
+
+func (n *simNAT) writeTo(data []byte, addr net.Addr) (int, error) {
+	dst := addr.(*net.UDPAddr)
+	key := behaviorKey(n.mapping, dst)
+	port, ok := n.mappings[key]
+	if !ok {
+		n.nextPort++
+		port = n.nextPort
+		n.mappings[key] = port
+	}
+	n.permits[behaviorKey(n.filtering, dst)] = true
+	req, err := parseStunMessage(data)
+	if err != nil {
+		return 0, err
+	}
+	resp, source := stunResponse5780(req, dst, &net.UDPAddr{IP: n.publicIP, Port: port},
+		net.IPv4(198, 51, 100, 1), net.IPv4(198, 51, 100, 2), 3478, 3479)
+	if n.permits[behaviorKey(n.filtering, source)] {
+		n.client.handlePacket(resp, source)
+	}
+	return len(data), nil
+}
+
+func TestClassifySimulatedNAT(t *testing.T) {
+	behaviors := []NATBehavior{EndpointIndependent, AddressDependent, AddressAndPortDependent}
+	for _, mapping := range behaviors {
+		for _, filtering := range behaviors {
+			nat := &simNAT{mapping: mapping, filtering: filtering, publicIP: net.IPv4(203, 0, 113, 9), nextPort: 40000,
+				mappings: make(map[string]int), permits: make(map[string]bool)}
+			nat.client = newStunClient(nat.writeTo)
+			local := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 20), Port: 5000}
+			server := &net.UDPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 3478}
+			info, err := nat.client.classify(context.Background(), local, []*net.UDPAddr{server}, 100*time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.NoNAT || info.Mapping != mapping || info.Filtering != filtering {
+				t.Fatalf("nat %s/%s classified as %s/%s", mapping, filtering, info.Mapping, info.Filtering)
+			}
+		}
+	}
+}
+
+func serveStun5780(t *testing.T) *net.UDPAddr {
+	return serveStun5780On(t, func(addr *net.UDPAddr) (net.PacketConn, error) {
+		return net.ListenUDP("udp", addr)
+	}, net.IPv4(127, 0, 0, 1), net.IPv4(127, 0, 0, 2))
+}
+
+// serveStun5780On runs an RFC 5780 STUN server on the conns listen opens
+// at ip1 and ip2, and returns its primary address.
+func serveStun5780On(t *testing.T, listen func(*net.UDPAddr) (net.PacketConn, error), ip1, ip2 net.IP) *net.UDPAddr {
+	first, err := listen(&net.UDPAddr{IP: ip1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	port1 := first.LocalAddr().(*net.UDPAddr).Port
+	var port2 int
+	conns := map[string]net.PacketConn{}
+	for _, ip := range []net.IP{ip1, ip2} {
+		for _, port := range []int{port1, 0} {
+			if ip.Equal(ip1) && port == port1 {
+				conns[first.LocalAddr().String()] = first
+				continue
+			}
+			if port == 0 && port2 != 0 {
+				port = port2
+			}
+			conn, err := listen(&net.UDPAddr{IP: ip, Port: port})
+			if err != nil {
+				t.Skip("no second loopback address: ", err)
+			}
+			if port == 0 {
+				port2 = conn.LocalAddr().(*net.UDPAddr).Port
+			}
+			conns[conn.LocalAddr().String()] = conn
+		}
+	}
+	for _, conn := range conns {
+		conn := conn
+		t.Cleanup(func() {
+			conn.Close()
+		})
+		go func() {
+			at := conn.LocalAddr().(*net.UDPAddr)
+			data := make([]byte, MaxPacketBufferSize)
+			for {
+				n, addr, err := conn.ReadFrom(data)
+				if err != nil {
+					return
+				}
+				from := addr.(*net.UDPAddr)
+				req, err := parseStunMessage(data[:n])
+				if err != nil || req.typ != stunBindingRequest {
+					continue
+				}
+				resp, source := stunResponse5780(req, at, from, ip1, ip2, port1, port2)
+				if out, ok := conns[source.String()]; ok {
+					out.WriteTo(resp, from)
+				}
+			}
+		}()
+	}
+	return &net.UDPAddr{IP: ip1, Port: port1}
+}
+
+func TestClassifyNAT(t *testing.T) {
+	server := serveStun5780(t)
+	listener := listenLocal(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	info, err := listener.ClassifyNAT(ctx, server.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.NoNAT || info.Mapping != EndpointIndependent || info.Filtering != EndpointIndependent {
+		t.Fatalf("loopback classified as nat=%v %s/%s", !info.NoNAT, info.Mapping, info.Filtering)
+	}
+	if info.MappedAddr.String() != listener.Addr().String() {
+		t.Fatalf("mapped %s, want %s", info.MappedAddr, listener.Addr())
+	}
+}
+
+// unixAddrConn is a transport whose addresses are not UDP addresses.
+type unixAddrConn struct {
+	net.PacketConn
+}
+
+func (c unixAddrConn) LocalAddr() net.Addr {
+	return &net.UnixAddr{Name: "kuic-test", Net: "unixgram"}
+}
+
+func TestClassifyNATWithoutUDPAddr(t *testing.T) {
+	network := netsim.New(netsim.LinkConfig{}, 1)
+	conn, err := network.Listen(&net.UDPAddr{IP: net.ParseIP("10.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	listener, err := ListenPacket(unixAddrConn{conn}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	if _, err := listener.ClassifyNAT(context.Background(), "198.51.100.1:3478"); !errors.Is(err, ErrNoUDPAddr) {
+		t.Fatalf("expected ErrNoUDPAddr, got %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+package netsim
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+type datagram struct {
+	data []byte
+	addr *net.UDPAddr
+}
+
+// PacketConn is a net.PacketConn on a Network.
+type PacketConn struct {
+	network       *Network
+	realm         *realm
+	nat           *NAT
+	addr          *net.UDPAddr
+	queue         chan datagram
+	closed        chan struct{}
+	closeOnce     sync.Once
+	readDeadline  *deadline
+	writeDeadline *deadline
+}
+
+func newPacketConn(network *Network, realm *realm, nat *NAT, addr *net.UDPAddr) *PacketConn {
+	return &PacketConn{network: network, realm: realm, nat: nat, addr: addr, queue: make(chan datagram, queueSize), closed: make(chan struct{}), readDeadline: newDeadline(), writeDeadline: newDeadline()}
+}
+
+// enqueue is called with the network locked, which orders it before the
+// conn is removed by Close.
+func (c *PacketConn) enqueue(d datagram) bool {
+	select {
+	case c.queue <- d:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *PacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	timeout := c.readDeadline.wait()
+	if isClosed(timeout) {
+		return 0, nil, c.opError("read", os.ErrDeadlineExceeded)
+	}
+	select {
+	case d := <-c.queue:
+		return copy(p, d.data), d.addr, nil
+	case <-c.closed:
+		return 0, nil, c.opError("read", net.ErrClosed)
+	case <-timeout:
+		return 0, nil, c.opError("read", os.ErrDeadlineExceeded)
+	}
+}
+
+func (c *PacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if isClosed(c.closed) {
+		return 0, c.opError("write", net.ErrClosed)
+	}
+	if isClosed(c.writeDeadline.wait()) {
+		return 0, c.opError("write", os.ErrDeadlineExceeded)
+	}
+	dst, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, c.opError("write", net.InvalidAddrError("not a UDP address"))
+	}
+	c.network.send(p, c, dst)
+	return len(p), nil
+}
+
+func (c *PacketConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.network.locker.Lock()
+		c.realm.remove(c)
+		c.network.locker.Unlock()
+		close(c.closed)
+	})
+	return nil
+}
+
+func (c *PacketConn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+func (c *PacketConn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *PacketConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *PacketConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *PacketConn) opError(op string, err error) error {
+	return &net.OpError{Op: op, Net: "udp", Addr: c.addr, Err: err}
+}
+
+// deadline closes done once it has passed, like the deadlines of net.Pipe.
+type deadline struct {
+	timer  *time.Timer
+	done   chan struct{}
+	locker *sync.Mutex
+}
+
+func newDeadline() *deadline {
+	return &deadline{done: make(chan struct{}), locker: new(sync.Mutex)}
+}
+
+func (d *deadline) set(t time.Time) {
+	d.locker.Lock()
+	defer d.locker.Unlock()
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.done
+	}
+	d.timer = nil
+	expired := isClosed(d.done)
+	if t.IsZero() || time.Until(t) > 0 {
+		if expired {
+			d.done = make(chan struct{})
+		}
+		if !t.IsZero() {
+			done := d.done
+			d.timer = time.AfterFunc(time.Until(t), func() {
+				close(done)
+			})
+		}
+		return
+	}
+	if !expired {
+		close(d.done)
+	}
+}
+
+func (d *deadline) wait() chan struct{} {
+	d.locker.Lock()
+	defer d.locker.Unlock()
+	return d.done
+}
+
+func isClosed(c chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
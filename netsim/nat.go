@@ -0,0 +1,176 @@
+package netsim
+
+import (
+	"net"
+	"time"
+)
+
+const firstMappedPort = 20000
+
+type NATType int
+
+const (
+	// FullCone lets anyone reach a mapping.
+	FullCone NATType = iota
+	// Restricted lets in packets from the IPs a mapping has sent to.
+	Restricted
+	// PortRestricted lets in packets from the addresses a mapping has
+	// sent to.
+	PortRestricted
+	// Symmetric maps every destination to a port of its own, which only
+	// that destination can reach.
+	Symmetric
+)
+
+func (t NATType) String() string {
+	switch t {
+	case FullCone:
+		return "full-cone"
+	case Restricted:
+		return "restricted"
+	case PortRestricted:
+		return "port-restricted"
+	case Symmetric:
+		return "symmetric"
+	}
+	return "unknown"
+}
+
+type NATConfig struct {
+	Type NATType
+	// MappingTimeout is how long a mapping lives without outgoing traffic;
+	// zero keeps mappings forever. An expired mapping is replaced by one
+	// on a new port.
+	MappingTimeout time.Duration
+	// Hairpinning lets hosts behind the NAT reach each other at their
+	// public addresses.
+	Hairpinning bool
+}
+
+type mapping struct {
+	private *net.UDPAddr
+	public  *net.UDPAddr
+	key     string
+	allowed map[string]bool
+	lastOut time.Time
+}
+
+// NAT is a NAT box with a public IP on a Network and a private network of
+// its own behind it. Hosts behind it reach each other at their private
+// addresses, and everyone else through its mappings.
+type NAT struct {
+	network  *Network
+	ip       net.IP
+	config   NATConfig
+	private  *realm
+	mappings map[string]*mapping
+	ports    map[int]*mapping
+	nextPort int
+}
+
+// NewNAT puts a NAT box on the network at ip.
+func (n *Network) NewNAT(ip net.IP, config NATConfig) (*NAT, error) {
+	if ip == nil {
+		return nil, ErrNoIP
+	}
+	n.locker.Lock()
+	defer n.locker.Unlock()
+	ip = normalize(ip)
+	if n.nats[ip.String()] != nil || n.public.uses(ip) {
+		return nil, ErrAddrInUse
+	}
+	nat := &NAT{network: n, ip: ip, config: config, private: newRealm(), mappings: make(map[string]*mapping), ports: make(map[int]*mapping), nextPort: firstMappedPort}
+	n.nats[ip.String()] = nat
+	return nat, nil
+}
+
+func (nat *NAT) IP() net.IP {
+	return nat.ip
+}
+
+// Listen opens a conn at the private address addr behind the NAT.
+func (nat *NAT) Listen(addr *net.UDPAddr) (*PacketConn, error) {
+	if addr == nil || addr.IP == nil {
+		return nil, ErrNoIP
+	}
+	nat.network.locker.Lock()
+	defer nat.network.locker.Unlock()
+	return nat.private.listen(nat.network, nat, addr)
+}
+
+// Mappings is the number of live mappings.
+func (nat *NAT) Mappings() int {
+	nat.network.locker.Lock()
+	defer nat.network.locker.Unlock()
+	now := time.Now()
+	live := 0
+	for _, m := range nat.mappings {
+		if !nat.expired(m, now) {
+			live++
+		}
+	}
+	return live
+}
+
+func (nat *NAT) expired(m *mapping, now time.Time) bool {
+	return nat.config.MappingTimeout > 0 && now.Sub(m.lastOut) > nat.config.MappingTimeout
+}
+
+func (nat *NAT) remove(m *mapping) {
+	delete(nat.mappings, m.key)
+	delete(nat.ports, m.public.Port)
+}
+
+func (nat *NAT) filterKey(addr *net.UDPAddr) string {
+	if nat.config.Type == Restricted {
+		return normalize(addr.IP).String()
+	}
+	return hostPort(normalize(addr.IP), addr.Port)
+}
+
+// outbound maps a packet from private to dst and returns the public
+// address it leaves with. It is called with the network locked.
+func (nat *NAT) outbound(private, dst *net.UDPAddr, now time.Time) *net.UDPAddr {
+	key := private.String()
+	if nat.config.Type == Symmetric {
+		key += "->" + hostPort(normalize(dst.IP), dst.Port)
+	}
+	m := nat.mappings[key]
+	if m != nil && nat.expired(m, now) {
+		nat.remove(m)
+		m = nil
+	}
+	if m == nil {
+		for nat.ports[nat.nextPort] != nil {
+			nat.nextPort++
+		}
+		m = &mapping{private: private, public: &net.UDPAddr{IP: nat.ip, Port: nat.nextPort}, key: key, allowed: make(map[string]bool)}
+		nat.nextPort++
+		nat.mappings[key] = m
+		nat.ports[m.public.Port] = m
+	}
+	m.lastOut = now
+	m.allowed[nat.filterKey(dst)] = true
+	return m.public
+}
+
+// inbound returns the host a packet from src to the public address dst is
+// let through to, nil if the NAT drops it. It is called with the network
+// locked.
+func (nat *NAT) inbound(src, dst *net.UDPAddr, now time.Time) *PacketConn {
+	if normalize(src.IP).Equal(nat.ip) && !nat.config.Hairpinning {
+		return nil
+	}
+	m := nat.ports[dst.Port]
+	if m == nil {
+		return nil
+	}
+	if nat.expired(m, now) {
+		nat.remove(m)
+		return nil
+	}
+	if nat.config.Type != FullCone && !m.allowed[nat.filterKey(src)] {
+		return nil
+	}
+	return nat.private.conn(m.private)
+}
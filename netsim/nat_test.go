@@ -0,0 +1,154 @@
+package netsim
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func behindNAT(t *testing.T, nat *NAT, ip string) *PacketConn {
+	conn, err := nat.Listen(&net.UDPAddr{IP: net.ParseIP(ip)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	return conn
+}
+
+// exchange sends from host to server and back from the given public
+// conns, and reports which of them got through the NAT.
+func exchange(t *testing.T, host *PacketConn, server *PacketConn, others ...*PacketConn) (mapped net.Addr, through []bool) {
+	t.Helper()
+	host.WriteTo([]byte("out"), server.LocalAddr())
+	server.SetReadDeadline(time.Now().Add(time.Second))
+	_, mapped, err := server.ReadFrom(make([]byte, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, other := range others {
+		other.WriteTo([]byte("in"), mapped)
+		host.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+		_, _, err := host.ReadFrom(make([]byte, 16))
+		through = append(through, err == nil)
+	}
+	return mapped, through
+}
+
+func TestNATFiltering(t *testing.T) {
+	for _, test := range []struct {
+		typ     NATType
+		through []bool
+	}{
+		{FullCone, []bool{true, true, true}},
+		{Restricted, []bool{true, true, false}},
+		{PortRestricted, []bool{true, false, false}},
+		{Symmetric, []bool{true, false, false}},
+	} {
+		t.Run(test.typ.String(), func(t *testing.T) {
+			network := New(LinkConfig{}, 1)
+			nat, err := network.NewNAT(net.ParseIP("203.0.113.1"), NATConfig{Type: test.typ})
+			if err != nil {
+				t.Fatal(err)
+			}
+			host := behindNAT(t, nat, "192.168.0.2")
+			server := listen(t, network, "198.51.100.1")
+			samePort := listen(t, network, "198.51.100.1")
+			otherIP := listen(t, network, "198.51.100.2")
+			mapped, through := exchange(t, host, server, server, samePort, otherIP)
+			if !mapped.(*net.UDPAddr).IP.Equal(nat.IP()) {
+				t.Fatalf("packet left the NAT from %v", mapped)
+			}
+			for i := range through {
+				if through[i] != test.through[i] {
+					t.Fatalf("%s let in %v, expected %v", test.typ, through, test.through)
+				}
+			}
+		})
+	}
+}
+
+func TestNATMapping(t *testing.T) {
+	for _, typ := range []NATType{FullCone, Symmetric} {
+		network := New(LinkConfig{}, 1)
+		nat, err := network.NewNAT(net.ParseIP("203.0.113.1"), NATConfig{Type: typ})
+		if err != nil {
+			t.Fatal(err)
+		}
+		host := behindNAT(t, nat, "192.168.0.2")
+		first, _ := exchange(t, host, listen(t, network, "198.51.100.1"))
+		second, _ := exchange(t, host, listen(t, network, "198.51.100.2"))
+		if same := first.String() == second.String(); same != (typ != Symmetric) {
+			t.Fatalf("%s mapped to %v and %v", typ, first, second)
+		}
+	}
+}
+
+func TestNATMappingTimeout(t *testing.T) {
+	network := New(LinkConfig{}, 1)
+	timeout := 50 * time.Millisecond
+	nat, err := network.NewNAT(net.ParseIP("203.0.113.1"), NATConfig{Type: FullCone, MappingTimeout: timeout})
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := behindNAT(t, nat, "192.168.0.2")
+	server := listen(t, network, "198.51.100.1")
+	first, through := exchange(t, host, server, server)
+	if !through[0] {
+		t.Fatal("answer did not get through a fresh mapping")
+	}
+	time.Sleep(2 * timeout)
+	server.WriteTo([]byte("late"), first)
+	host.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, _, err := host.ReadFrom(make([]byte, 16)); err == nil {
+		t.Fatal("packet got through an expired mapping")
+	}
+	if nat.Mappings() != 0 {
+		t.Fatal("expired mapping still counted")
+	}
+	second, _ := exchange(t, host, server)
+	if first.String() == second.String() {
+		t.Fatalf("expired mapping %v was reused", first)
+	}
+	if stats := network.Stats(); stats.Filtered != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestNATHairpinning(t *testing.T) {
+	for _, hairpinning := range []bool{false, true} {
+		network := New(LinkConfig{}, 1)
+		nat, err := network.NewNAT(net.ParseIP("203.0.113.1"), NATConfig{Type: FullCone, Hairpinning: hairpinning})
+		if err != nil {
+			t.Fatal(err)
+		}
+		a := behindNAT(t, nat, "192.168.0.2")
+		b := behindNAT(t, nat, "192.168.0.3")
+		server := listen(t, network, "198.51.100.1")
+		mappedB, _ := exchange(t, b, server)
+
+		a.WriteTo([]byte("lan"), b.LocalAddr())
+		b.SetReadDeadline(time.Now().Add(time.Second))
+		if _, from, err := b.ReadFrom(make([]byte, 16)); err != nil || from.String() != a.LocalAddr().String() {
+			t.Fatalf("private address unreachable behind the NAT: %v %v", from, err)
+		}
+
+		a.WriteTo([]byte("hairpin"), mappedB)
+		b.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+		_, from, err := b.ReadFrom(make([]byte, 16))
+		if (err == nil) != hairpinning {
+			t.Fatalf("hairpinning %v, packet through: %v", hairpinning, err == nil)
+		}
+		if err == nil && !from.(*net.UDPAddr).IP.Equal(nat.IP()) {
+			t.Fatalf("hairpinned packet came from %v", from)
+		}
+	}
+	network := New(LinkConfig{}, 1)
+	if _, err := network.NewNAT(net.ParseIP("203.0.113.1"), NATConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := network.Listen(&net.UDPAddr{IP: net.ParseIP("203.0.113.1")}); err != ErrAddrInUse {
+		t.Fatalf("listened on the IP of a NAT: %v", err)
+	}
+}
@@ -0,0 +1,232 @@
+// Package netsim is an in-memory packet network for tests, with UDP-like
+// addresses and links that lose, delay, reorder and size-limit packets.
+package netsim
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultMTU is the largest UDP payload of a link without a configured MTU,
+// that of an IPv4 packet on Ethernet.
+const DefaultMTU = 1472
+
+const (
+	firstEphemeralPort = 49152
+	// queueSize is the number of packets a conn holds for its reader, like
+	// the receive buffer of a socket.
+	queueSize = 1024
+)
+
+var (
+	ErrAddrInUse = errors.New("netsim: address already in use")
+	ErrNoIP      = errors.New("netsim: address has no IP")
+)
+
+// LinkConfig describes what the network does to packets in flight. The
+// zero value delivers every packet at once and in order.
+type LinkConfig struct {
+	// Loss is the probability that a packet is dropped.
+	Loss float64
+	// Delay is how long a packet takes to arrive, plus a random part of
+	// up to Jitter, which reorders packets sent closer than it.
+	Delay  time.Duration
+	Jitter time.Duration
+	// Reorder is the probability that a packet skips Delay and Jitter and
+	// overtakes the packets in flight, as in netem.
+	Reorder float64
+	// MTU is the largest payload the link carries, DefaultMTU if zero;
+	// larger packets are dropped.
+	MTU int
+}
+
+func (c *LinkConfig) mtu() int {
+	if c.MTU <= 0 {
+		return DefaultMTU
+	}
+	return c.MTU
+}
+
+type Stats struct {
+	Sent      uint64
+	Delivered uint64
+	// Lost counts the packets dropped by Loss, TooBig those over the MTU,
+	// Filtered those a NAT did not let in and Unreachable those to an
+	// address nobody listens on or whose queue was full.
+	Lost        uint64
+	TooBig      uint64
+	Filtered    uint64
+	Unreachable uint64
+}
+
+// Network connects the PacketConns listening on it. Its randomness comes
+// from a seeded source, so a test without Delay or Jitter sees the same
+// packets lost on every run.
+type Network struct {
+	link   LinkConfig
+	rand   *rand.Rand
+	public *realm
+	nats   map[string]*NAT
+	stats  Stats
+	locker *sync.Mutex
+}
+
+func New(link LinkConfig, seed int64) *Network {
+	return &Network{link: link, rand: rand.New(rand.NewSource(seed)), public: newRealm(), nats: make(map[string]*NAT), locker: new(sync.Mutex)}
+}
+
+// SetLink changes the link for the packets sent from now on.
+func (n *Network) SetLink(link LinkConfig) {
+	n.locker.Lock()
+	defer n.locker.Unlock()
+	n.link = link
+}
+
+func (n *Network) Stats() Stats {
+	n.locker.Lock()
+	defer n.locker.Unlock()
+	return n.stats
+}
+
+// Listen opens a conn at addr; port zero picks a free port of its IP.
+func (n *Network) Listen(addr *net.UDPAddr) (*PacketConn, error) {
+	if addr == nil || addr.IP == nil {
+		return nil, ErrNoIP
+	}
+	n.locker.Lock()
+	defer n.locker.Unlock()
+	if n.nats[normalize(addr.IP).String()] != nil {
+		return nil, ErrAddrInUse
+	}
+	return n.public.listen(n, nil, addr)
+}
+
+// send takes a copy of data from conn to dst through the link, and through
+// the NAT conn is behind unless dst is behind it too.
+func (n *Network) send(data []byte, conn *PacketConn, dst *net.UDPAddr) {
+	n.locker.Lock()
+	n.stats.Sent++
+	if len(data) > n.link.mtu() {
+		n.stats.TooBig++
+		n.locker.Unlock()
+		return
+	}
+	if n.link.Loss > 0 && n.rand.Float64() < n.link.Loss {
+		n.stats.Lost++
+		n.locker.Unlock()
+		return
+	}
+	delay := n.link.Delay
+	if n.link.Jitter > 0 {
+		delay += time.Duration(n.rand.Int63n(int64(n.link.Jitter)))
+	}
+	if n.link.Reorder > 0 && n.rand.Float64() < n.link.Reorder {
+		delay = 0
+	}
+	d := datagram{data: append([]byte(nil), data...), addr: conn.addr}
+	nat := conn.nat
+	if nat != nil && nat.private.conn(dst) == nil {
+		d.addr = nat.outbound(conn.addr, dst, time.Now())
+		nat = nil
+	}
+	n.locker.Unlock()
+	if delay <= 0 {
+		n.deliver(d, nat, dst)
+		return
+	}
+	time.AfterFunc(delay, func() {
+		n.deliver(d, nat, dst)
+	})
+}
+
+// deliver hands d to dst, which is a private address behind nat or a
+// public one if nat is nil.
+func (n *Network) deliver(d datagram, nat *NAT, dst *net.UDPAddr) {
+	n.locker.Lock()
+	defer n.locker.Unlock()
+	var conn *PacketConn
+	switch {
+	case nat != nil:
+		conn = nat.private.conn(dst)
+	case n.nats[normalize(dst.IP).String()] != nil:
+		conn = n.nats[normalize(dst.IP).String()].inbound(d.addr, dst, time.Now())
+		if conn == nil {
+			n.stats.Filtered++
+			return
+		}
+	default:
+		conn = n.public.conn(dst)
+	}
+	if conn == nil || !conn.enqueue(d) {
+		n.stats.Unreachable++
+		return
+	}
+	n.stats.Delivered++
+}
+
+// realm is an address space, the public network or the one behind a NAT.
+type realm struct {
+	conns map[string]*PacketConn
+	ports map[string]int
+}
+
+func newRealm() *realm {
+	return &realm{conns: make(map[string]*PacketConn), ports: make(map[string]int)}
+}
+
+func (r *realm) listen(network *Network, nat *NAT, addr *net.UDPAddr) (*PacketConn, error) {
+	local := &net.UDPAddr{IP: normalize(addr.IP), Port: addr.Port}
+	if local.Port == 0 {
+		ip := local.IP.String()
+		port := r.ports[ip]
+		if port < firstEphemeralPort {
+			port = firstEphemeralPort
+		}
+		for r.conns[hostPort(local.IP, port)] != nil {
+			port++
+		}
+		local.Port = port
+		r.ports[ip] = port + 1
+	}
+	key := local.String()
+	if r.conns[key] != nil {
+		return nil, ErrAddrInUse
+	}
+	conn := newPacketConn(network, r, nat, local)
+	r.conns[key] = conn
+	return conn, nil
+}
+
+func (r *realm) conn(addr *net.UDPAddr) *PacketConn {
+	return r.conns[hostPort(normalize(addr.IP), addr.Port)]
+}
+
+func (r *realm) uses(ip net.IP) bool {
+	for _, conn := range r.conns {
+		if conn.addr.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *realm) remove(conn *PacketConn) {
+	if r.conns[conn.addr.String()] == conn {
+		delete(r.conns, conn.addr.String())
+	}
+}
+
+func normalize(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip
+}
+
+func hostPort(ip net.IP, port int) string {
+	return net.JoinHostPort(ip.String(), strconv.Itoa(port))
+}
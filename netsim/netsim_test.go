@@ -0,0 +1,130 @@
+package netsim
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func listen(t *testing.T, network *Network, ip string) *PacketConn {
+	conn, err := network.Listen(&net.UDPAddr{IP: net.ParseIP(ip)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	return conn
+}
+
+func read(t *testing.T, conn *PacketConn) []byte {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	data := make([]byte, 2048)
+	n, _, err := conn.ReadFrom(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data[:n]
+}
+
+func TestListen(t *testing.T) {
+	network := New(LinkConfig{}, 1)
+	a := listen(t, network, "10.0.0.1")
+	b := listen(t, network, "10.0.0.1")
+	if a.LocalAddr().String() == b.LocalAddr().String() {
+		t.Fatalf("two conns at %v", a.LocalAddr())
+	}
+	if _, err := network.Listen(a.LocalAddr().(*net.UDPAddr)); !errors.Is(err, ErrAddrInUse) {
+		t.Fatalf("expected ErrAddrInUse, got %v", err)
+	}
+	if _, err := network.Listen(&net.UDPAddr{Port: 1}); !errors.Is(err, ErrNoIP) {
+		t.Fatalf("expected ErrNoIP, got %v", err)
+	}
+	a.WriteTo([]byte("hello"), b.LocalAddr())
+	if data := read(t, b); string(data) != "hello" {
+		t.Fatalf("unexpected packet %q", data)
+	}
+	a.Close()
+	if _, _, err := a.ReadFrom(make([]byte, 16)); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected net.ErrClosed, got %v", err)
+	}
+	b.WriteTo([]byte("gone"), a.LocalAddr())
+	if stats := network.Stats(); stats.Delivered != 1 || stats.Unreachable != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if _, err := network.Listen(a.LocalAddr().(*net.UDPAddr)); err != nil {
+		t.Fatalf("closed address not reusable: %v", err)
+	}
+}
+
+func TestLoss(t *testing.T) {
+	lost := func(seed int64) uint64 {
+		network := New(LinkConfig{Loss: 0.3}, seed)
+		a := listen(t, network, "10.0.0.1")
+		b := listen(t, network, "10.0.0.2")
+		for i := 0; i < 1000; i++ {
+			a.WriteTo([]byte{byte(i)}, b.LocalAddr())
+		}
+		return network.Stats().Lost
+	}
+	first := lost(7)
+	if first < 200 || first > 400 {
+		t.Fatalf("lost %d of 1000 packets at 30%% loss", first)
+	}
+	if second := lost(7); second != first {
+		t.Fatalf("same seed lost %d then %d packets", first, second)
+	}
+}
+
+func TestMTU(t *testing.T) {
+	network := New(LinkConfig{MTU: 100}, 1)
+	a := listen(t, network, "10.0.0.1")
+	b := listen(t, network, "10.0.0.2")
+	a.WriteTo(make([]byte, 101), b.LocalAddr())
+	a.WriteTo(make([]byte, 100), b.LocalAddr())
+	if data := read(t, b); len(data) != 100 {
+		t.Fatalf("received a %d byte packet", len(data))
+	}
+	if stats := network.Stats(); stats.TooBig != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestDelayAndReorder(t *testing.T) {
+	delay := 50 * time.Millisecond
+	network := New(LinkConfig{Delay: delay}, 1)
+	a := listen(t, network, "10.0.0.1")
+	b := listen(t, network, "10.0.0.2")
+	start := time.Now()
+	a.WriteTo([]byte{1}, b.LocalAddr())
+	network.SetLink(LinkConfig{Delay: delay, Reorder: 1})
+	a.WriteTo([]byte{2}, b.LocalAddr())
+	if data := read(t, b); data[0] != 2 {
+		t.Fatalf("packet %d arrived first", data[0])
+	}
+	if data := read(t, b); data[0] != 1 {
+		t.Fatalf("packet %d arrived second", data[0])
+	}
+	if elapsed := time.Since(start); elapsed < delay {
+		t.Fatalf("delayed packet arrived after %v", elapsed)
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	network := New(LinkConfig{}, 1)
+	a := listen(t, network, "10.0.0.1")
+	a.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	_, _, err := a.ReadFrom(make([]byte, 16))
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	a.SetReadDeadline(time.Time{})
+	a.WriteTo([]byte("again"), a.LocalAddr())
+	if data := read(t, a); string(data) != "again" {
+		t.Fatalf("unexpected packet %q", data)
+	}
+}
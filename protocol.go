@@ -3,3 +3,44 @@ package kuic
 const MaxPacketBufferSize = 1452
 
 const MaxSeqNum uint16 = 0x7FFF
+
+// Every datagram ends with a header trailer, so that the QUIC packet in front
+// of it can be handed to quic-go without copying:
+//
+//	type(1) flags(1) service(1) gen(1) seq(2) version(1) magic(2)
+//
+// service picks the server a packet to the server role is for; replies carry
+// it back unchanged. gen is the generation of seq, which tells a packet meant
+// for a conn that has since been closed from one for the conn that reuses its
+// seq; 0 means unknown, as for legacy peers.
+//
+// version changes with the layout, so a peer that reads the header with
+// different fields drops the packet instead of misrouting it. Version 1 had
+// neither service nor gen, and version 2 had no service.
+const (
+	headerMagic   uint16 = 0x4B55
+	headerVersion byte   = 3
+	headerSize           = 9
+)
+
+// Before the versioned header, kuic appended nothing but the seq. Control
+// packets were tagged with legacyControlSeq and carried their type in the
+// first payload byte; the matching seq MaxSeqNum is never handed out by
+// seqStack.
+const (
+	legacyHeaderSize        = 2
+	legacyControlSeq uint16 = 0xFFFF
+)
+
+// Packet types. The control types keep the values they had as the first
+// payload byte of legacy control packets.
+const (
+	packetQuic            byte = 0x00
+	controlPunch          byte = 0x01
+	controlPunchAck       byte = 0x02
+	controlRelayAllocate  byte = 0x03
+	controlRelayAllocated byte = 0x04
+	controlRelayData      byte = 0x05
+	controlKeepalive      byte = 0x06
+	maxPacketType              = controlKeepalive
+)
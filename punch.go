@@ -0,0 +1,124 @@
+package kuic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+var ErrPunchFailed = errors.New("punch failed")
+
+const punchInterval = 200 * time.Millisecond
+
+const punchAttempts = 25
+
+type punchState struct {
+	acked chan struct{}
+	once  *sync.Once
+}
+
+func (s *punchState) ack() {
+	s.once.Do(func() {
+		close(s.acked)
+	})
+}
+
+type puncher struct {
+	states map[string]*punchState
+	locker *sync.Mutex
+}
+
+func newPuncher() *puncher {
+	return &puncher{states: make(map[string]*punchState), locker: new(sync.Mutex)}
+}
+
+func (p *puncher) start(key string) *punchState {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	state, ok := p.states[key]
+	if !ok {
+		state = &punchState{acked: make(chan struct{}), once: new(sync.Once)}
+		p.states[key] = state
+	}
+	return state
+}
+
+func (p *puncher) stop(key string, state *punchState) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	if p.states[key] == state {
+		delete(p.states, key)
+	}
+}
+
+func (p *puncher) ack(key string) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	if state, ok := p.states[key]; ok {
+		state.ack()
+	}
+}
+
+func (bs *baseServer) writeControl(typ byte, payload []byte, addr net.Addr) (int, error) {
+	data := bs.framing.appendControl(make([]byte, 0, len(payload)+headerSize), typ, payload, addr)
+	return bs.writeRaw(data, addr)
+}
+
+func (bs *baseServer) handleControl(typ byte, data []byte, addr net.Addr) {
+	switch typ {
+	case controlPunch:
+		bs.writeControl(controlPunchAck, nil, addr)
+	case controlPunchAck:
+		bs.puncher.ack(addr.String())
+	case controlRelayAllocate:
+		bs.handleRelayAllocate(data, addr)
+	case controlRelayAllocated:
+		bs.handleRelayAllocated(data, addr)
+	case controlRelayData:
+		bs.handleRelayData(data, addr)
+	case controlKeepalive:
+		// run has already recorded the peer as seen
+	}
+}
+
+// punch sends probes to peer until the peer answers one of them, so that
+// both NATs hold a mapping for the pair before the QUIC handshake starts.
+// The peer is expected to punch towards us at the same time.
+func (bs *baseServer) punch(ctx context.Context, peer *net.UDPAddr) error {
+	key := peer.String()
+	state := bs.puncher.start(key)
+	defer bs.puncher.stop(key, state)
+	ticker := time.NewTicker(punchInterval)
+	defer ticker.Stop()
+	for i := 0; i < punchAttempts; i++ {
+		_, err := bs.writeControl(controlPunch, nil, peer)
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrPunchFailed, peer, err)
+		}
+		select {
+		case <-state.acked:
+			return nil
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %s: %v", ErrPunchFailed, peer, ctx.Err())
+		case <-bs.context.Done():
+			return net.ErrClosed
+		case <-ticker.C:
+		}
+	}
+	return fmt.Errorf("%w: no answer from %s after %d probes", ErrPunchFailed, peer, punchAttempts)
+}
+
+func (l *Listener) Punch(ctx context.Context, peer *net.UDPAddr) error {
+	return l.baseServer.punch(ctx, peer)
+}
+
+func (l *Listener) DialWithPunch(ctx context.Context, peer *net.UDPAddr) (Connection, error) {
+	err := l.baseServer.punch(ctx, peer)
+	if err != nil {
+		return nil, err
+	}
+	return l.baseServer.dialContext(ctx, peer, DefaultService, l.baseServer.config)
+}
@@ -0,0 +1,94 @@
+package kuic
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func listenLocal(t *testing.T) *Listener {
+	listener, err := Listen(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		listener.Close()
+	})
+	return listener
+}
+
+func TestPunch(t *testing.T) {
+	a := listenLocal(t)
+	b := listenLocal(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- b.Punch(ctx, a.Addr())
+	}()
+	go func() {
+		conn, err := b.Accept()
+		if err != nil {
+			return
+		}
+		stream, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		data := make([]byte, 16)
+		n, _ := stream.Read(data)
+		stream.Write(data[:n])
+		stream.Close()
+	}()
+	conn, err := a.DialWithPunch(ctx, b.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	stream, err := conn.OpenStreamSync(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Write([]byte("punch"))
+	data, err := io.ReadAll(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "punch" {
+		t.Fatalf("unexpected echo %q", data)
+	}
+}
+
+func TestPunchFailed(t *testing.T) {
+	a := listenLocal(t)
+	silent, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	err = a.Punch(ctx, silent.LocalAddr().(*net.UDPAddr))
+	if !errors.Is(err, ErrPunchFailed) {
+		t.Fatalf("expected ErrPunchFailed, got %v", err)
+	}
+}
+
+func TestDialWithPunchContext(t *testing.T) {
+	a := listenLocal(t)
+	// answers punches, but nothing takes the handshake
+	peer := baseServerLocal(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := a.DialWithPunch(ctx, peer.sockets[0].conn.LocalAddr().(*net.UDPAddr))
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 2*time.Second {
+		t.Fatalf("handshake did not end with the context: %v after %v", err, time.Since(start))
+	}
+}
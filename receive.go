@@ -0,0 +1,134 @@
+package kuic
+
+import (
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"net"
+	"sync"
+)
+
+// readBatchSize is the number of datagrams run asks the kernel for in one
+// recvmmsg call.
+const readBatchSize = 8
+
+var bufferPool = sync.Pool{New: func() any {
+	buffer := make([]byte, MaxPacketBufferSize)
+	return &buffer
+}}
+
+func getBuffer() *[]byte {
+	return bufferPool.Get().(*[]byte)
+}
+
+func putBuffer(buffer *[]byte) {
+	if buffer != nil {
+		bufferPool.Put(buffer)
+	}
+}
+
+// batchReader is implemented by both ipv4.PacketConn and ipv6.PacketConn;
+// their Message types are the same.
+type batchReader interface {
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+// loopReader reads one datagram per batch from transports that cannot do
+// more.
+type loopReader struct {
+	conn net.PacketConn
+}
+
+func (r *loopReader) ReadBatch(ms []ipv4.Message, flags int) (int, error) {
+	n, addr, err := r.conn.ReadFrom(ms[0].Buffers[0])
+	if err != nil {
+		return 0, err
+	}
+	ms[0].N, ms[0].Addr = n, addr
+	return 1, nil
+}
+
+// batchConn is an x/net packet conn of either address family.
+type batchConn interface {
+	batchReader
+	batchWriter
+}
+
+func newPacketConn(conn *net.UDPConn) batchConn {
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok && addr.IP.To4() != nil {
+		return ipv4.NewPacketConn(conn)
+	}
+	return ipv6.NewPacketConn(conn)
+}
+
+func newBatchReader(conn net.PacketConn) batchReader {
+	switch conn := conn.(type) {
+	case *net.UDPConn:
+		return newUDPReader(conn)
+	case batchReader:
+		return conn
+	}
+	return &loopReader{conn: conn}
+}
+
+// run reads datagrams in batches into pooled buffers. A buffer routed to a
+// BasicConn belongs to it until its ReadFrom has copied the packet out, and
+// its slot in the batch gets a fresh buffer; all other buffers are reused
+// in place.
+func (bs *baseServer) run(socket *socket) {
+	defer close(socket.readerDone)
+	reader := newBatchReader(socket.conn)
+	multi := len(bs.sockets) > 1
+	buffers := make([]*[]byte, readBatchSize)
+	messages := make([]ipv4.Message, readBatchSize)
+	for i := range messages {
+		buffers[i] = getBuffer()
+		messages[i].Buffers = [][]byte{*buffers[i]}
+	}
+	for {
+		n, err := reader.ReadBatch(messages, 0)
+		if err != nil {
+			return
+		}
+		for i := 0; i < n; i++ {
+			if multi {
+				bs.routes.see(messages[i].Addr, socket)
+			}
+			if bs.receive(buffers[i], messages[i].N, messages[i].Addr) {
+				buffers[i] = getBuffer()
+				messages[i].Buffers[0] = *buffers[i]
+			}
+		}
+	}
+}
+
+// receive dispatches one datagram and reports whether it kept buffer.
+func (bs *baseServer) receive(buffer *[]byte, n int, addr net.Addr) bool {
+	data := (*buffer)[:n]
+	if isStunPacket(data) {
+		bs.stunClient.handlePacket(data, addr)
+		return false
+	}
+	if bs.interop.isPlain(data, addr) {
+		serverConn := bs.registry.services[DefaultService].Load()
+		if serverConn == nil {
+			return false
+		}
+		serverConn.handlePacket(packet{num: n, from: Addr{Addr: addr, plain: true}, data: data, buffer: buffer})
+		return true
+	}
+	h, payload, ok := bs.framing.parse(data, addr)
+	if !ok {
+		return false
+	}
+	bs.keepalive.seen(addr)
+	if h.typ != packetQuic {
+		bs.handleControl(h.typ, payload, addr)
+		return false
+	}
+	bb, from, ok := bs.getBasicConn(h, addr)
+	if !ok {
+		return false
+	}
+	bb.handlePacket(packet{num: len(payload), from: from, data: data, buffer: buffer})
+	return true
+}
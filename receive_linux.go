@@ -0,0 +1,127 @@
+//go:build linux
+
+package kuic
+
+import (
+	"encoding/binary"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/sys/unix"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// maxPeers bounds the source addresses an mmsgReader keeps; past it the
+// cache starts over, which only costs allocations.
+const maxPeers = 4096
+
+type mmsghdr struct {
+	hdr unix.Msghdr
+	len uint32
+}
+
+type peerKey struct {
+	addr  netip.AddrPort
+	scope uint32
+}
+
+// mmsgReader reads batches with recvmmsg like ipv4.PacketConn does, but
+// hands out the same *net.UDPAddr for every datagram of a peer, so that a
+// read allocates nothing.
+type mmsgReader struct {
+	rawConn syscall.RawConn
+	headers []mmsghdr
+	iovecs  []unix.Iovec
+	names   []unix.RawSockaddrInet6
+	peers   map[peerKey]*net.UDPAddr
+	read    func(fd uintptr) bool
+	count   int
+	n       int
+	errno   syscall.Errno
+}
+
+func newUDPReader(conn *net.UDPConn) batchReader {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return newPacketConn(conn)
+	}
+	r := &mmsgReader{
+		rawConn: rawConn,
+		headers: make([]mmsghdr, readBatchSize),
+		iovecs:  make([]unix.Iovec, readBatchSize),
+		names:   make([]unix.RawSockaddrInet6, readBatchSize),
+		peers:   make(map[peerKey]*net.UDPAddr),
+	}
+	r.read = func(fd uintptr) bool {
+		n, _, errno := unix.Syscall6(unix.SYS_RECVMMSG, fd, uintptr(unsafe.Pointer(&r.headers[0])), uintptr(r.count), 0, 0, 0)
+		if errno == unix.EAGAIN || errno == unix.EINTR {
+			return false
+		}
+		r.n, r.errno = int(n), errno
+		return true
+	}
+	return r
+}
+
+func (r *mmsgReader) ReadBatch(ms []ipv4.Message, flags int) (int, error) {
+	r.count = len(ms)
+	if r.count > len(r.headers) {
+		r.count = len(r.headers)
+	}
+	for i := 0; i < r.count; i++ {
+		buffer := ms[i].Buffers[0]
+		r.iovecs[i].Base = &buffer[0]
+		r.iovecs[i].SetLen(len(buffer))
+		h := &r.headers[i].hdr
+		h.Name = (*byte)(unsafe.Pointer(&r.names[i]))
+		h.Namelen = unix.SizeofSockaddrInet6
+		h.Iov = &r.iovecs[i]
+		h.SetIovlen(1)
+	}
+	if err := r.rawConn.Read(r.read); err != nil {
+		return 0, err
+	}
+	if r.errno != 0 {
+		return 0, os.NewSyscallError("recvmmsg", r.errno)
+	}
+	for i := 0; i < r.n; i++ {
+		ms[i].N = int(r.headers[i].len)
+		ms[i].Addr = r.peer(&r.names[i])
+	}
+	return r.n, nil
+}
+
+// peer decodes a source address, allocating only for a peer not seen yet.
+func (r *mmsgReader) peer(name *unix.RawSockaddrInet6) net.Addr {
+	var key peerKey
+	switch name.Family {
+	case unix.AF_INET:
+		sa := (*unix.RawSockaddrInet4)(unsafe.Pointer(name))
+		key.addr = netip.AddrPortFrom(netip.AddrFrom4(sa.Addr), networkPort(&sa.Port))
+	case unix.AF_INET6:
+		key.addr = netip.AddrPortFrom(netip.AddrFrom16(name.Addr), networkPort(&name.Port))
+		key.scope = name.Scope_id
+	default:
+		return nil
+	}
+	if addr, ok := r.peers[key]; ok {
+		return addr
+	}
+	if len(r.peers) >= maxPeers {
+		r.peers = make(map[peerKey]*net.UDPAddr)
+	}
+	addr := &net.UDPAddr{IP: key.addr.Addr().AsSlice(), Port: int(key.addr.Port())}
+	if key.scope != 0 {
+		addr.Zone = strconv.FormatUint(uint64(key.scope), 10)
+	}
+	r.peers[key] = addr
+	return addr
+}
+
+// networkPort reads a port the way the kernel stores it, big endian.
+func networkPort(port *uint16) uint16 {
+	return binary.BigEndian.Uint16((*[2]byte)(unsafe.Pointer(port))[:])
+}
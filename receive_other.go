@@ -0,0 +1,11 @@
+//go:build !linux
+
+package kuic
+
+import (
+	"net"
+)
+
+func newUDPReader(conn *net.UDPConn) batchReader {
+	return newPacketConn(conn)
+}
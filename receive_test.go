@@ -0,0 +1,99 @@
+package kuic
+
+import (
+	"golang.org/x/net/ipv4"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func benchmarkReceive(b *testing.B, size int) {
+	listener, err := Listen(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer listener.Close()
+	conn, err := listener.baseServer.GetClientConn()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+	sender, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer sender.Close()
+	to := listener.Addr().AddrPort()
+	data := appendHeader(make([]byte, size), header{seq: conn.LocalAddr().(*Addr).seq})
+	// the sender keeps a window of packets in flight, smaller than the
+	// conn's queue, so that the benchmark measures delivery and not drops
+	window := make(chan struct{}, 64)
+	for i := 0; i < cap(window); i++ {
+		window <- struct{}{}
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-window:
+			case <-done:
+				return
+			}
+			sender.WriteToUDPAddrPort(data, to)
+		}
+	}()
+	buf := make([]byte, MaxPacketBufferSize)
+	b.SetBytes(int64(size))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := conn.ReadFrom(buf); err != nil {
+			b.Fatal(err)
+		}
+		window <- struct{}{}
+	}
+}
+
+func BenchmarkReceiveSmall(b *testing.B) {
+	benchmarkReceive(b, 64)
+}
+
+func BenchmarkReceiveFull(b *testing.B) {
+	benchmarkReceive(b, quicPacketSize)
+}
+
+func TestReadBatchAddrs(t *testing.T) {
+	for _, ip := range []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback} {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: ip})
+		if err != nil {
+			t.Skip("no loopback for ", ip, ": ", err)
+		}
+		defer conn.Close()
+		peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: ip})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer peer.Close()
+		reader := newBatchReader(conn)
+		messages := make([]ipv4.Message, 1)
+		messages[0].Buffers = [][]byte{make([]byte, MaxPacketBufferSize)}
+		var addrs []net.Addr
+		for _, data := range []string{"one", "two"} {
+			peer.WriteTo([]byte(data), conn.LocalAddr())
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			n, err := reader.ReadBatch(messages, 0)
+			if err != nil || n != 1 || string(messages[0].Buffers[0][:messages[0].N]) != data {
+				t.Fatalf("read %d messages: %v", n, err)
+			}
+			addrs = append(addrs, messages[0].Addr)
+		}
+		if addrs[0].String() != peer.LocalAddr().String() {
+			t.Fatalf("datagram from %v read as from %v", peer.LocalAddr(), addrs[0])
+		}
+		if runtime.GOOS == "linux" && addrs[0] != addrs[1] {
+			t.Fatal("the same peer was read as two addresses")
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package kuic
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type ConnRole int
+
+const (
+	// RoleServer is the conn a service accepts every connection on.
+	RoleServer ConnRole = iota
+	// RoleClient is the conn of one dialed connection.
+	RoleClient
+)
+
+func (r ConnRole) String() string {
+	if r == RoleServer {
+		return "server"
+	}
+	return "client"
+}
+
+// ConnInfo describes a virtual conn of a Listener. RemoteAddr is nil for a
+// server conn, which is shared by every connection accepted by its service,
+// and for a client conn that has not sent anything yet.
+type ConnInfo struct {
+	Seq           uint16
+	Role          ConnRole
+	Service       ServiceID
+	RemoteAddr    net.Addr
+	Created       time.Time
+	BytesReceived uint64
+	BytesSent     uint64
+	Stats         ConnStats
+}
+
+// registry maps the service or seq of a packet to its virtual conn. Lookups
+// happen for every packet and never take a lock; client conns are keyed by
+// the seq the peer addresses them with, their seq with the top bit set.
+type registry struct {
+	services [maxServices]atomic.Pointer[BasicConn]
+	clients  sync.Map
+}
+
+func (r *registry) client(lSeq uint16) (*BasicConn, bool) {
+	conn, ok := r.clients.Load(lSeq)
+	if !ok {
+		return nil, false
+	}
+	return conn.(*BasicConn), true
+}
+
+func (r *registry) add(lSeq uint16, conn *BasicConn) {
+	r.clients.Store(lSeq, conn)
+}
+
+func (r *registry) remove(lSeq uint16) {
+	r.clients.Delete(lSeq)
+}
+
+func (r *registry) conns() []*BasicConn {
+	var conns []*BasicConn
+	for i := range r.services {
+		if server := r.services[i].Load(); server != nil {
+			conns = append(conns, server)
+		}
+	}
+	r.clients.Range(func(_, conn any) bool {
+		conns = append(conns, conn.(*BasicConn))
+		return true
+	})
+	return conns
+}
+
+// Conns lists the virtual conns of the listener, the server conns by service
+// first and the client conns by seq.
+func (l *Listener) Conns() []ConnInfo {
+	conns := l.baseServer.registry.conns()
+	infos := make([]ConnInfo, 0, len(conns))
+	for _, conn := range conns {
+		infos = append(infos, conn.info())
+	}
+	sort.SliceStable(infos, func(i, j int) bool {
+		if infos[i].Role != infos[j].Role {
+			return infos[i].Role == RoleServer
+		}
+		if infos[i].Role == RoleServer {
+			return infos[i].Service < infos[j].Service
+		}
+		return infos[i].Seq < infos[j].Seq
+	})
+	return infos
+}
@@ -0,0 +1,92 @@
+package kuic
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConns(t *testing.T) {
+	a := listenLocal(t)
+	b := listenLocal(t)
+	go func() {
+		conn, err := b.Accept()
+		if err != nil {
+			return
+		}
+		stream, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		io.Copy(stream, stream)
+		stream.Close()
+	}()
+	conn, err := a.Dial(b.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := echo(conn); err != nil {
+		t.Fatal(err)
+	}
+	conns := a.Conns()
+	if len(conns) != 2 || conns[0].Role != RoleServer || conns[1].Role != RoleClient {
+		t.Fatalf("unexpected conns %+v", conns)
+	}
+	client := conns[1]
+	if client.RemoteAddr.String() != b.Addr().String() || client.BytesSent == 0 || client.BytesReceived == 0 || client.Created.IsZero() {
+		t.Fatalf("unexpected client conn %+v", client)
+	}
+	if server := b.Conns()[0]; server.BytesReceived == 0 || server.RemoteAddr != nil {
+		t.Fatalf("unexpected server conn %+v", server)
+	}
+
+	conn.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(a.Conns()) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("closed conn still registered: %+v", a.Conns())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRegistryConcurrency(t *testing.T) {
+	listener := listenLocal(t)
+	sender, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for seq := uint16(0x8000); ; seq++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			sender.WriteTo(appendHeader(nil, header{seq: 0x8000 | seq&0xFF}), listener.Addr())
+		}
+	}()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				conn, err := listener.baseServer.GetClientConn()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				listener.Conns()
+				conn.Close()
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,428 @@
+package kuic
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	ErrRelayInUse         = errors.New("relay: peer id in use")
+	ErrRelayUnavailable   = errors.New("relay: node does not relay")
+	ErrRelayNotRegistered = errors.New("relay: not registered with relay")
+	ErrRelayPeerID        = errors.New("relay: peer id must be 1 to 255 bytes")
+)
+
+const (
+	relayStatusOK byte = iota
+	relayStatusInUse
+	relayStatusDisabled
+)
+
+const DefaultRelayLifetime = 10 * time.Minute
+
+const DefaultRelayBandwidth = 1 << 20
+
+const relayRetryInterval = 500 * time.Millisecond
+
+// RelayAddr is the address of a peer reached through a relay node.
+type RelayAddr struct {
+	Relay  *net.UDPAddr
+	PeerID string
+}
+
+func (a *RelayAddr) Network() string {
+	return "udp"
+}
+func (a *RelayAddr) String() string {
+	return a.Relay.String() + "/" + a.PeerID
+}
+
+type RelayConfig struct {
+	// Lifetime of an allocation that is not refreshed by its peer.
+	Lifetime time.Duration
+	// Bandwidth is the number of bytes per second an allocation may send.
+	Bandwidth int
+}
+
+type RelayAllocation struct {
+	PeerID  string
+	Addr    net.Addr
+	Expires time.Time
+	Bytes   uint64
+	Dropped uint64
+}
+
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int) *tokenBucket {
+	burst := float64(rate)
+	if burst < MaxPacketBufferSize {
+		burst = MaxPacketBufferSize
+	}
+	return &tokenBucket{rate: float64(rate), burst: burst, tokens: burst, last: time.Now()}
+}
+
+func (b *tokenBucket) allow(n int, now time.Time) bool {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	if b.tokens < float64(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+type relayAllocation struct {
+	peerID  string
+	addr    net.Addr
+	expires time.Time
+	bucket  *tokenBucket
+	bytes   uint64
+	dropped uint64
+}
+
+type relayServer struct {
+	config *RelayConfig
+	peers  map[string]*relayAllocation
+	addrs  map[string]*relayAllocation
+	locker *sync.Mutex
+}
+
+func newRelayServer(config *RelayConfig) *relayServer {
+	conf := RelayConfig{Lifetime: DefaultRelayLifetime, Bandwidth: DefaultRelayBandwidth}
+	if config != nil {
+		if config.Lifetime > 0 {
+			conf.Lifetime = config.Lifetime
+		}
+		if config.Bandwidth > 0 {
+			conf.Bandwidth = config.Bandwidth
+		}
+	}
+	return &relayServer{config: &conf, peers: make(map[string]*relayAllocation), addrs: make(map[string]*relayAllocation), locker: new(sync.Mutex)}
+}
+
+func (r *relayServer) remove(allocation *relayAllocation) {
+	delete(r.peers, allocation.peerID)
+	if r.addrs[allocation.addr.String()] == allocation {
+		delete(r.addrs, allocation.addr.String())
+	}
+}
+
+func (r *relayServer) sweep(now time.Time) {
+	for _, allocation := range r.peers {
+		if now.After(allocation.expires) {
+			r.remove(allocation)
+		}
+	}
+}
+
+func (r *relayServer) allocate(peerID string, addr net.Addr) byte {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	now := time.Now()
+	r.sweep(now)
+	allocation, ok := r.peers[peerID]
+	if ok && allocation.addr.String() != addr.String() {
+		return relayStatusInUse
+	}
+	if !ok {
+		if old, ok := r.addrs[addr.String()]; ok {
+			r.remove(old)
+		}
+		allocation = &relayAllocation{peerID: peerID, addr: addr, bucket: newTokenBucket(r.config.Bandwidth)}
+		r.peers[peerID] = allocation
+		r.addrs[addr.String()] = allocation
+	}
+	allocation.expires = now.Add(r.config.Lifetime)
+	return relayStatusOK
+}
+
+// route looks up the sender's and the target's allocation and charges the
+// sender for n bytes.
+func (r *relayServer) route(from net.Addr, target string, n int) (string, net.Addr, bool) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	now := time.Now()
+	source, ok := r.addrs[from.String()]
+	if !ok || now.After(source.expires) {
+		return "", nil, false
+	}
+	to, ok := r.peers[target]
+	if !ok || now.After(to.expires) {
+		return "", nil, false
+	}
+	if !source.bucket.allow(n, now) {
+		source.dropped++
+		return "", nil, false
+	}
+	source.bytes += uint64(n)
+	return source.peerID, to.addr, true
+}
+
+func (r *relayServer) allocations() []*RelayAllocation {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	r.sweep(time.Now())
+	allocations := make([]*RelayAllocation, 0, len(r.peers))
+	for _, allocation := range r.peers {
+		allocations = append(allocations, &RelayAllocation{
+			PeerID:  allocation.peerID,
+			Addr:    allocation.addr,
+			Expires: allocation.expires,
+			Bytes:   allocation.bytes,
+			Dropped: allocation.dropped,
+		})
+	}
+	return allocations
+}
+
+type relayRegistration struct {
+	peerID   string
+	lifetime time.Duration
+	answer   chan byte
+}
+
+type relayClient struct {
+	registrations map[string]*relayRegistration
+	locker        *sync.Mutex
+}
+
+func newRelayClient() *relayClient {
+	return &relayClient{registrations: make(map[string]*relayRegistration), locker: new(sync.Mutex)}
+}
+
+func (c *relayClient) get(relay string) (*relayRegistration, bool) {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	registration, ok := c.registrations[relay]
+	return registration, ok
+}
+
+// validPeerID reports whether peerID fits the one length byte of the relay
+// header.
+func validPeerID(peerID string) bool {
+	return len(peerID) > 0 && len(peerID) <= 255
+}
+
+func encodeRelayHeader(peerID string, size int) []byte {
+	data := make([]byte, 0, 1+len(peerID)+size)
+	data = append(data, byte(len(peerID)))
+	return append(data, peerID...)
+}
+
+func decodeRelayPacket(data []byte) (string, []byte, bool) {
+	if len(data) < 1 || len(data) < 1+int(data[0]) {
+		return "", nil, false
+	}
+	end := 1 + int(data[0])
+	return string(data[1:end]), data[end:], true
+}
+
+func (bs *baseServer) writeRelay(ps []byte, a *Addr, addr *RelayAddr) (int, error) {
+	if !validPeerID(addr.PeerID) {
+		return 0, ErrRelayPeerID
+	}
+	if _, ok := bs.relayClient.get(addr.Relay.String()); !ok {
+		return 0, ErrRelayNotRegistered
+	}
+	data := encodeRelayHeader(addr.PeerID, len(ps)+headerSize)
+	data = bs.framing.appendPacket(append(data, ps...), a, addr)
+	_, err := bs.writeControl(controlRelayData, data, addr.Relay)
+	if err != nil {
+		return 0, err
+	}
+	return len(ps), nil
+}
+
+func (bs *baseServer) handleRelayAllocate(data []byte, addr net.Addr) {
+	if !validPeerID(string(data)) {
+		return
+	}
+	relay := bs.relayServer.Load()
+	if relay == nil {
+		bs.writeControl(controlRelayAllocated, []byte{relayStatusDisabled, 0, 0, 0, 0}, addr)
+		return
+	}
+	status := relay.allocate(string(data), addr)
+	answer := []byte{status, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(answer[1:], uint32(relay.config.Lifetime/time.Millisecond))
+	bs.writeControl(controlRelayAllocated, answer, addr)
+}
+
+func (bs *baseServer) handleRelayAllocated(data []byte, addr net.Addr) {
+	if len(data) < 5 {
+		return
+	}
+	bs.relayClient.locker.Lock()
+	registration, ok := bs.relayClient.registrations[addr.String()]
+	if ok {
+		if lifetime := time.Duration(binary.BigEndian.Uint32(data[1:5])) * time.Millisecond; lifetime > 0 {
+			registration.lifetime = lifetime
+		}
+	}
+	bs.relayClient.locker.Unlock()
+	if !ok {
+		return
+	}
+	select {
+	case registration.answer <- data[0]:
+	default:
+	}
+}
+
+func (bs *baseServer) handleRelayData(data []byte, addr net.Addr) {
+	peerID, inner, ok := decodeRelayPacket(data)
+	if !ok || len(inner) == 0 {
+		return
+	}
+	if relay := bs.relayServer.Load(); relay != nil {
+		source, to, ok := relay.route(addr, peerID, len(inner))
+		if ok {
+			bs.writeControl(controlRelayData, append(encodeRelayHeader(source, len(inner)), inner...), to)
+		}
+		return
+	}
+	if _, ok := bs.relayClient.get(addr.String()); !ok {
+		return
+	}
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return
+	}
+	relayAddr := &RelayAddr{Relay: udpAddr, PeerID: peerID}
+	h, payload, ok := bs.framing.parse(inner, relayAddr)
+	if !ok || h.typ != packetQuic {
+		return
+	}
+	bb, from, ok := bs.getBasicConn(h, relayAddr)
+	if !ok {
+		return
+	}
+	buffer := getBuffer()
+	copy(*buffer, payload)
+	bb.handlePacket(packet{num: len(payload), from: from, data: *buffer, buffer: buffer})
+}
+
+func (bs *baseServer) sendAllocate(relay *net.UDPAddr, peerID string) error {
+	_, err := bs.writeControl(controlRelayAllocate, []byte(peerID), relay)
+	return err
+}
+
+func (bs *baseServer) registerRelay(ctx context.Context, relay *net.UDPAddr, peerID string) error {
+	if !validPeerID(peerID) {
+		return fmt.Errorf("%w: %q", ErrRelayPeerID, peerID)
+	}
+	key := relay.String()
+	registration := &relayRegistration{peerID: peerID, lifetime: DefaultRelayLifetime, answer: make(chan byte, 1)}
+	bs.relayClient.locker.Lock()
+	bs.relayClient.registrations[key] = registration
+	bs.relayClient.locker.Unlock()
+	ticker := time.NewTicker(relayRetryInterval)
+	defer ticker.Stop()
+	for {
+		err := bs.sendAllocate(relay, peerID)
+		if err != nil {
+			bs.unregisterRelay(key, registration)
+			return err
+		}
+		select {
+		case status := <-registration.answer:
+			switch status {
+			case relayStatusOK:
+				go bs.refreshRelay(relay, registration)
+				return nil
+			case relayStatusInUse:
+				err = ErrRelayInUse
+			default:
+				err = ErrRelayUnavailable
+			}
+			bs.unregisterRelay(key, registration)
+			return err
+		case <-ctx.Done():
+			bs.unregisterRelay(key, registration)
+			return fmt.Errorf("relay: no answer from %s: %w", relay, ctx.Err())
+		case <-bs.context.Done():
+			bs.unregisterRelay(key, registration)
+			return net.ErrClosed
+		case <-ticker.C:
+		}
+	}
+}
+
+func (bs *baseServer) unregisterRelay(key string, registration *relayRegistration) {
+	bs.relayClient.locker.Lock()
+	defer bs.relayClient.locker.Unlock()
+	if bs.relayClient.registrations[key] == registration {
+		delete(bs.relayClient.registrations, key)
+	}
+}
+
+// refreshRelay renews the allocation at half its lifetime for as long as the
+// registration stays current.
+func (bs *baseServer) refreshRelay(relay *net.UDPAddr, registration *relayRegistration) {
+	for {
+		bs.relayClient.locker.Lock()
+		interval := registration.lifetime / 2
+		bs.relayClient.locker.Unlock()
+		select {
+		case <-time.After(interval):
+		case <-bs.context.Done():
+			return
+		}
+		current, ok := bs.relayClient.get(relay.String())
+		if !ok || current != registration {
+			return
+		}
+		bs.sendAllocate(relay, registration.peerID)
+	}
+}
+
+func (bs *baseServer) dialRelay(relay *net.UDPAddr, peerID string) (Connection, error) {
+	if !validPeerID(peerID) {
+		return nil, fmt.Errorf("%w: %q", ErrRelayPeerID, peerID)
+	}
+	if _, ok := bs.relayClient.get(relay.String()); !ok {
+		return nil, ErrRelayNotRegistered
+	}
+	return bs.dial(&RelayAddr{Relay: relay, PeerID: peerID})
+}
+
+// EnableRelay turns the listener into a relay node that forwards packets
+// between peers registered with RegisterRelay.
+func (l *Listener) EnableRelay(config *RelayConfig) {
+	l.baseServer.relayServer.Store(newRelayServer(config))
+}
+
+func (l *Listener) RelayAllocations() []*RelayAllocation {
+	relay := l.baseServer.relayServer.Load()
+	if relay == nil {
+		return nil
+	}
+	return relay.allocations()
+}
+
+// RegisterRelay allocates peerID on the relay node and keeps the allocation
+// alive, so that other peers can reach this listener through DialViaRelay.
+func (l *Listener) RegisterRelay(ctx context.Context, relay *net.UDPAddr, peerID string) error {
+	return l.baseServer.registerRelay(ctx, relay, peerID)
+}
+
+// DialViaRelay opens a connection to peerID through a relay this listener is
+// registered with. The QUIC session runs end to end, the relay only forwards
+// encrypted packets.
+func (l *Listener) DialViaRelay(relay *net.UDPAddr, peerID string) (Connection, error) {
+	return l.baseServer.dialRelay(relay, peerID)
+}
@@ -0,0 +1,127 @@
+package kuic
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDialViaRelay(t *testing.T) {
+	relay := listenLocal(t)
+	relay.EnableRelay(nil)
+	a := listenLocal(t)
+	b := listenLocal(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := a.DialViaRelay(relay.Addr(), "b"); !errors.Is(err, ErrRelayNotRegistered) {
+		t.Fatalf("expected ErrRelayNotRegistered, got %v", err)
+	}
+	long := strings.Repeat("x", 256)
+	if err := a.RegisterRelay(ctx, relay.Addr(), long); !errors.Is(err, ErrRelayPeerID) {
+		t.Fatalf("expected ErrRelayPeerID registering a long id, got %v", err)
+	}
+	if err := a.RegisterRelay(ctx, relay.Addr(), "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.DialViaRelay(relay.Addr(), long); !errors.Is(err, ErrRelayPeerID) {
+		t.Fatalf("expected ErrRelayPeerID dialing a long id, got %v", err)
+	}
+	if err := b.RegisterRelay(ctx, relay.Addr(), "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.RegisterRelay(ctx, relay.Addr(), "a"); !errors.Is(err, ErrRelayInUse) {
+		t.Fatalf("expected ErrRelayInUse, got %v", err)
+	}
+	if err := b.RegisterRelay(ctx, relay.Addr(), "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		conn, err := b.Accept()
+		if err != nil {
+			return
+		}
+		stream, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		io.Copy(stream, stream)
+		stream.Close()
+	}()
+	conn, err := a.DialViaRelay(relay.Addr(), "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	remote, ok := conn.RemoteAddr().(*RelayAddr)
+	if !ok || remote.PeerID != "b" {
+		t.Fatalf("unexpected remote addr %v", conn.RemoteAddr())
+	}
+	stream, err := conn.OpenStreamSync(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Write([]byte("relayed"))
+	stream.Close()
+	data, err := io.ReadAll(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "relayed" {
+		t.Fatalf("unexpected echo %q", data)
+	}
+	var forwarded uint64
+	for _, allocation := range relay.RelayAllocations() {
+		forwarded += allocation.Bytes
+	}
+	if forwarded == 0 {
+		t.Fatal("relay forwarded nothing")
+	}
+}
+
+func TestRelayLimits(t *testing.T) {
+	relay := listenLocal(t)
+	relay.EnableRelay(&RelayConfig{Lifetime: 300 * time.Millisecond, Bandwidth: 100})
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	peer.WriteTo(appendHeader([]byte("p"), header{typ: controlRelayAllocate}), relay.Addr())
+	peer.SetReadDeadline(time.Now().Add(2 * time.Second))
+	answer := make([]byte, 16)
+	n, _, err := peer.ReadFrom(answer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h, payload, ok := parseHeader(answer[:n]); !ok || h.typ != controlRelayAllocated || payload[0] != relayStatusOK {
+		t.Fatalf("unexpected answer %v", answer)
+	}
+
+	// the burst allows one full packet, the second one is over the limit
+	payload := make([]byte, MaxPacketBufferSize-1-len("p")-2*headerSize)
+	data := append(encodeRelayHeader("p", len(payload)), payload...)
+	data = appendHeader(appendHeader(data, header{seq: 1}), header{typ: controlRelayData})
+	peer.WriteTo(data, relay.Addr())
+	peer.WriteTo(data, relay.Addr())
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		allocations := relay.RelayAllocations()
+		if len(allocations) == 1 && allocations[0].Dropped == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("second packet was not dropped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	time.Sleep(400 * time.Millisecond)
+	if allocations := relay.RelayAllocations(); len(allocations) != 0 {
+		t.Fatalf("allocation outlived its lifetime: %v", allocations[0])
+	}
+}
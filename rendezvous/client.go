@@ -0,0 +1,157 @@
+package rendezvous
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/chuccp/kuic"
+	"github.com/quic-go/quic-go"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+var ErrClientClosed = errors.New("rendezvous client closed")
+
+// introductionQueueSize is the number of introductions a client holds for
+// its reader before it drops them.
+const introductionQueueSize = 16
+
+type Client struct {
+	conn          kuic.Connection
+	stream        quic.Stream
+	encoder       *json.Encoder
+	req           uint64
+	pending       map[uint64]chan *message
+	introductions chan *Introduction
+	dropped       atomic.Uint64
+	closed        chan struct{}
+	locker        *sync.Mutex
+}
+
+func NewClient(conn kuic.Connection) (*Client, error) {
+	stream, err := conn.OpenStreamSync(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	client := &Client{
+		conn:          conn,
+		stream:        stream,
+		encoder:       json.NewEncoder(stream),
+		pending:       make(map[uint64]chan *message),
+		introductions: make(chan *Introduction, introductionQueueSize),
+		closed:        make(chan struct{}),
+		locker:        new(sync.Mutex),
+	}
+	go client.run()
+	return client, nil
+}
+
+func Dial(listener *kuic.Listener, server *net.UDPAddr) (*Client, error) {
+	conn, err := listener.Dial(server)
+	if err != nil {
+		return nil, err
+	}
+	client, err := NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+func (c *Client) run() {
+	defer close(c.closed)
+	decoder := json.NewDecoder(c.stream)
+	for {
+		msg := new(message)
+		err := decoder.Decode(msg)
+		if err != nil {
+			return
+		}
+		if msg.Type == typeIntroduction {
+			addr, err := net.ResolveUDPAddr("udp", msg.Addr)
+			if err != nil {
+				continue
+			}
+			select {
+			case c.introductions <- &Introduction{PeerID: msg.Peer, Addr: addr}:
+			default:
+				c.dropped.Add(1)
+			}
+			continue
+		}
+		c.locker.Lock()
+		ch, ok := c.pending[msg.Req]
+		delete(c.pending, msg.Req)
+		c.locker.Unlock()
+		if ok {
+			ch <- msg
+		}
+	}
+}
+
+func (c *Client) request(msg *message) (*message, error) {
+	ch := make(chan *message, 1)
+	c.locker.Lock()
+	c.req++
+	msg.Req = c.req
+	c.pending[msg.Req] = ch
+	err := c.encoder.Encode(msg)
+	if err != nil {
+		delete(c.pending, msg.Req)
+		c.locker.Unlock()
+		return nil, err
+	}
+	c.locker.Unlock()
+	select {
+	case reply := <-ch:
+		return reply, reply.err()
+	case <-c.closed:
+		return nil, ErrClientClosed
+	}
+}
+
+func (c *Client) requestAddr(msg *message) (*net.UDPAddr, error) {
+	reply, err := c.request(msg)
+	if err != nil {
+		return nil, err
+	}
+	return net.ResolveUDPAddr("udp", reply.Addr)
+}
+
+// Register records this peer under id and returns the address the server
+// observed it from. It fails with ErrPeerIDTaken while another peer is
+// registered under id.
+func (c *Client) Register(id string) (*net.UDPAddr, error) {
+	return c.requestAddr(&message{Type: typeRegister, Peer: id})
+}
+
+func (c *Client) Lookup(id string) (*net.UDPAddr, error) {
+	return c.requestAddr(&message{Type: typeLookup, Peer: id})
+}
+
+// Introduce asks the server to send our observed address to peer id and
+// returns the peer's observed address, so that both sides can dial at once.
+func (c *Client) Introduce(id string) (*Introduction, error) {
+	addr, err := c.requestAddr(&message{Type: typeIntroduce, Peer: id})
+	if err != nil {
+		return nil, err
+	}
+	return &Introduction{PeerID: id, Addr: addr}, nil
+}
+
+// Introductions delivers the introductions other peers asked for. The
+// client never waits for its reader: introductions that find the channel
+// full are dropped and counted by DroppedIntroductions.
+func (c *Client) Introductions() <-chan *Introduction {
+	return c.introductions
+}
+
+func (c *Client) DroppedIntroductions() uint64 {
+	return c.dropped.Load()
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
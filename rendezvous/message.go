@@ -0,0 +1,48 @@
+package rendezvous
+
+import (
+	"errors"
+	"net"
+)
+
+var (
+	ErrPeerNotFound  = errors.New("peer not found")
+	ErrNotRegistered = errors.New("not registered")
+	ErrPeerIDTaken   = errors.New("peer id taken")
+)
+
+const (
+	typeRegister     = "register"
+	typeLookup       = "lookup"
+	typeIntroduce    = "introduce"
+	typeIntroduction = "introduction"
+	typeReply        = "reply"
+)
+
+type message struct {
+	Type  string `json:"type"`
+	Req   uint64 `json:"req,omitempty"`
+	Peer  string `json:"peer,omitempty"`
+	Addr  string `json:"addr,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+func (m *message) err() error {
+	if m.Error == "" {
+		return nil
+	}
+	switch m.Error {
+	case ErrPeerNotFound.Error():
+		return ErrPeerNotFound
+	case ErrNotRegistered.Error():
+		return ErrNotRegistered
+	case ErrPeerIDTaken.Error():
+		return ErrPeerIDTaken
+	}
+	return errors.New(m.Error)
+}
+
+type Introduction struct {
+	PeerID string
+	Addr   *net.UDPAddr
+}
@@ -0,0 +1,181 @@
+package rendezvous
+
+import (
+	"context"
+	"errors"
+	"github.com/chuccp/kuic"
+	"net"
+	"testing"
+	"time"
+)
+
+func listenLocal(t *testing.T) *kuic.Listener {
+	listener, err := kuic.Listen(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		listener.Close()
+	})
+	return listener
+}
+
+func TestIntroduce(t *testing.T) {
+	server, err := Listen(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	go server.Serve()
+
+	a := listenLocal(t)
+	b := listenLocal(t)
+	clientA, err := Dial(a, server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientA.Close()
+	clientB, err := Dial(b, server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientB.Close()
+
+	observed, err := clientA.Register("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if observed.String() != a.Addr().String() {
+		t.Fatalf("observed %s, want %s", observed, a.Addr())
+	}
+	if _, err := clientB.Register("b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientA.Lookup("c"); !errors.Is(err, ErrPeerNotFound) {
+		t.Fatalf("expected ErrPeerNotFound, got %v", err)
+	}
+	addr, err := clientA.Lookup("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.String() != b.Addr().String() {
+		t.Fatalf("lookup %s, want %s", addr, b.Addr())
+	}
+
+	introduction, err := clientA.Introduce("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pushed *Introduction
+	select {
+	case pushed = <-clientB.Introductions():
+	case <-time.After(5 * time.Second):
+		t.Fatal("no introduction for b")
+	}
+	if pushed.PeerID != "a" || pushed.Addr.String() != a.Addr().String() {
+		t.Fatalf("unexpected introduction %s %s", pushed.PeerID, pushed.Addr)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- b.Punch(ctx, pushed.Addr)
+	}()
+	conn, err := a.DialWithPunch(ctx, introduction.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIntroductionErrors(t *testing.T) {
+	server, err := Listen(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	go server.Serve()
+	clientA, err := Dial(listenLocal(t), server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientA.Close()
+	clientB, err := Dial(listenLocal(t), server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientB.Close()
+	if _, err := clientB.Register("b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientA.Introduce("b"); !errors.Is(err, ErrNotRegistered) {
+		t.Fatalf("expected ErrNotRegistered introducing an unregistered peer, got %v", err)
+	}
+	if _, err := clientA.Register("a"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < introductionQueueSize+3; i++ {
+		if _, err := clientA.Introduce("b"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for clientB.DroppedIntroductions() != 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d introductions dropped, expected 3", clientB.DroppedIntroductions())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRegisterTakenID(t *testing.T) {
+	server, err := Listen(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	go server.Serve()
+	clientA, err := Dial(listenLocal(t), server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientA.Close()
+	clientB, err := Dial(listenLocal(t), server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientB.Close()
+	addrA, err := clientA.Register("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientA.Register("a"); err != nil {
+		t.Fatalf("re-registering its own id: %v", err)
+	}
+	if _, err := clientB.Register("a"); !errors.Is(err, ErrPeerIDTaken) {
+		t.Fatalf("expected ErrPeerIDTaken, got %v", err)
+	}
+	addr, err := clientB.Lookup("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.String() != addrA.String() {
+		t.Fatalf("a resolves to %s, expected %s", addr, addrA)
+	}
+	clientA.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := clientB.Register("a")
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, ErrPeerIDTaken) || time.Now().After(deadline) {
+			t.Fatalf("registering a released id: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,150 @@
+package rendezvous
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/chuccp/kuic"
+	"net"
+	"sync"
+)
+
+type session struct {
+	conn    kuic.Connection
+	addr    net.Addr
+	encoder *json.Encoder
+	locker  *sync.Mutex
+}
+
+func (s *session) send(msg *message) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	return s.encoder.Encode(msg)
+}
+
+type Server struct {
+	listener *kuic.Listener
+	peers    map[string]*session
+	locker   *sync.RWMutex
+}
+
+func NewServer(listener *kuic.Listener) *Server {
+	return &Server{listener: listener, peers: make(map[string]*session), locker: new(sync.RWMutex)}
+}
+
+func Listen(addr *net.UDPAddr) (*Server, error) {
+	listener, err := kuic.Listen(addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewServer(listener), nil
+}
+
+func (s *Server) Addr() *net.UDPAddr {
+	return s.listener.Addr()
+}
+
+func (s *Server) Serve() error {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handleConn(conn)
+	}
+}
+
+func (s *Server) Close() error {
+	return s.listener.Close()
+}
+
+func (s *Server) lookup(id string) (*session, bool) {
+	s.locker.RLock()
+	defer s.locker.RUnlock()
+	peer, ok := s.peers[id]
+	return peer, ok
+}
+
+// register records sess under id, unless another session holds it; added
+// is false if sess already had id.
+func (s *Server) register(id string, sess *session) (added bool, ok bool) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	if peer, found := s.peers[id]; found {
+		return false, peer == sess
+	}
+	s.peers[id] = sess
+	return true, true
+}
+
+func (s *Server) handleConn(conn kuic.Connection) {
+	defer conn.Close()
+	stream, err := conn.AcceptStream(context.Background())
+	if err != nil {
+		return
+	}
+	sess := &session{conn: conn, addr: conn.RemoteAddr(), encoder: json.NewEncoder(stream), locker: new(sync.Mutex)}
+	var ids []string
+	defer func() {
+		s.locker.Lock()
+		defer s.locker.Unlock()
+		for _, id := range ids {
+			if s.peers[id] == sess {
+				delete(s.peers, id)
+			}
+		}
+	}()
+	decoder := json.NewDecoder(stream)
+	for {
+		msg := new(message)
+		err := decoder.Decode(msg)
+		if err != nil {
+			return
+		}
+		reply := &message{Type: typeReply, Req: msg.Req}
+		switch msg.Type {
+		case typeRegister:
+			added, ok := s.register(msg.Peer, sess)
+			if !ok {
+				reply.Error = ErrPeerIDTaken.Error()
+				break
+			}
+			if added {
+				ids = append(ids, msg.Peer)
+			}
+			reply.Peer = msg.Peer
+			reply.Addr = sess.addr.String()
+		case typeLookup:
+			peer, ok := s.lookup(msg.Peer)
+			if ok {
+				reply.Peer = msg.Peer
+				reply.Addr = peer.addr.String()
+			} else {
+				reply.Error = ErrPeerNotFound.Error()
+			}
+		case typeIntroduce:
+			// the peer has to know who to punch back to
+			if len(ids) == 0 {
+				reply.Error = ErrNotRegistered.Error()
+				break
+			}
+			peer, ok := s.lookup(msg.Peer)
+			if !ok {
+				reply.Error = ErrPeerNotFound.Error()
+				break
+			}
+			err := peer.send(&message{Type: typeIntroduction, Peer: ids[0], Addr: sess.addr.String()})
+			if err != nil {
+				reply.Error = err.Error()
+				break
+			}
+			reply.Peer = msg.Peer
+			reply.Addr = peer.addr.String()
+		default:
+			reply.Error = "unknown message type " + msg.Type
+		}
+		err = sess.send(reply)
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package kuic
+
+import (
+	"errors"
+	"golang.org/x/net/ipv4"
+	"net"
+	"os"
+	"sync/atomic"
+)
+
+const (
+	// writeBatchSize is the most packets a sendmmsg call carries, and so
+	// the most segments one GSO message is built from.
+	writeBatchSize = 32
+	sendQueueSize  = 256
+)
+
+type batchWriter interface {
+	WriteBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+type outPacket struct {
+	data   []byte
+	buffer *[]byte
+	addr   net.Addr
+}
+
+// sender owns the write side of the socket. WriteTo hands it framed packets
+// in pooled buffers, and it writes whatever has queued up in one batch, with
+// runs of packets to the same peer coalesced into GSO messages where the
+// kernel supports it.
+type sender struct {
+	writer   batchWriter
+	queue    chan outPacket
+	closed   chan struct{}
+	gso      atomic.Bool
+	batch    []outPacket
+	messages []ipv4.Message
+	scratch  []byte
+	oob      []byte
+}
+
+// loopWriter writes a batch one datagram at a time, for transports that
+// cannot do more.
+type loopWriter struct {
+	conn net.PacketConn
+}
+
+func (w *loopWriter) WriteBatch(ms []ipv4.Message, flags int) (int, error) {
+	for i, m := range ms {
+		if _, err := w.conn.WriteTo(m.Buffers[0], m.Addr); err != nil {
+			return i, err
+		}
+	}
+	return len(ms), nil
+}
+
+func newWriter(conn net.PacketConn) batchWriter {
+	switch conn := conn.(type) {
+	case *net.UDPConn:
+		return newBatchWriter(conn)
+	case batchWriter:
+		return conn
+	}
+	return &loopWriter{conn: conn}
+}
+
+func newSender(conn net.PacketConn) *sender {
+	s := &sender{
+		writer:   newWriter(conn),
+		queue:    make(chan outPacket, sendQueueSize),
+		closed:   make(chan struct{}),
+		batch:    make([]outPacket, 0, writeBatchSize),
+		messages: make([]ipv4.Message, writeBatchSize),
+		scratch:  make([]byte, writeBatchSize*MaxPacketBufferSize),
+	}
+	for i := range s.messages {
+		s.messages[i].Buffers = make([][]byte, 1)
+	}
+	udpConn, ok := conn.(*net.UDPConn)
+	s.gso.Store(ok && gsoSupported(udpConn))
+	return s
+}
+
+// send queues data, which lives in buffer, and takes ownership of buffer. It
+// waits for room in the queue until timeout is closed.
+func (s *sender) send(data []byte, buffer *[]byte, addr net.Addr, timeout <-chan struct{}) error {
+	if isClosed(s.closed) {
+		putBuffer(buffer)
+		return net.ErrClosed
+	}
+	select {
+	case s.queue <- outPacket{data: data, buffer: buffer, addr: addr}:
+		return nil
+	case <-s.closed:
+		putBuffer(buffer)
+		return net.ErrClosed
+	case <-timeout:
+		putBuffer(buffer)
+		return os.ErrDeadlineExceeded
+	}
+}
+
+// run writes until done is closed or the socket is. Packets still queued
+// then are dropped; later sends fail with net.ErrClosed.
+func (s *sender) run(done <-chan struct{}) {
+	defer s.stop()
+	for {
+		select {
+		case p := <-s.queue:
+			s.batch = append(s.batch[:0], p)
+		case <-done:
+			return
+		}
+	drain:
+		for len(s.batch) < writeBatchSize {
+			select {
+			case p := <-s.queue:
+				s.batch = append(s.batch, p)
+			default:
+				break drain
+			}
+		}
+		err := s.flush(s.gso.Load())
+		if isGSOError(err) {
+			s.gso.Store(false)
+			err = s.flush(false)
+		}
+		for _, p := range s.batch {
+			putBuffer(p.buffer)
+		}
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+	}
+}
+
+func (s *sender) stop() {
+	close(s.closed)
+	for {
+		select {
+		case p := <-s.queue:
+			putBuffer(p.buffer)
+		default:
+			return
+		}
+	}
+}
+
+func samePeer(a, b net.Addr) bool {
+	if a == b {
+		return true
+	}
+	ua, ok := a.(*net.UDPAddr)
+	ub, ok2 := b.(*net.UDPAddr)
+	return ok && ok2 && sameAddr(ua, ub)
+}
+
+// flush writes the batch. With gso, consecutive packets to the same peer are
+// copied into one message segmented by the size of its first packet; only
+// the last segment may be shorter.
+func (s *sender) flush(gso bool) error {
+	k, offset := 0, 0
+	s.oob = s.oob[:0]
+	for i := 0; i < len(s.batch); {
+		p := s.batch[i]
+		j := i + 1
+		for gso && j < len(s.batch) && samePeer(s.batch[j].addr, p.addr) && len(s.batch[j].data) <= len(p.data) {
+			j++
+			if len(s.batch[j-1].data) < len(p.data) {
+				break
+			}
+		}
+		m := &s.messages[k]
+		m.Addr, m.OOB = p.addr, nil
+		if j-i == 1 {
+			m.Buffers[0] = p.data
+		} else {
+			start := offset
+			for _, q := range s.batch[i:j] {
+				offset += copy(s.scratch[offset:], q.data)
+			}
+			m.Buffers[0] = s.scratch[start:offset]
+			oobStart := len(s.oob)
+			s.oob = appendUDPSegment(s.oob, len(p.data))
+			m.OOB = s.oob[oobStart:]
+		}
+		k++
+		i = j
+	}
+	return s.write(s.messages[:k])
+}
+
+// write sends ms, skipping a message the kernel refuses (a peer that is
+// unreachable should not cost the others their packets). A GSO failure is
+// returned at once so the batch can be resent without it.
+func (s *sender) write(ms []ipv4.Message) error {
+	var first error
+	for len(ms) > 0 {
+		n, err := s.writer.WriteBatch(ms, 0)
+		if err == nil && n == 0 {
+			break
+		}
+		if err != nil {
+			if isGSOError(err) || errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			if first == nil {
+				first = err
+			}
+			n++
+		}
+		if n > len(ms) {
+			n = len(ms)
+		}
+		ms = ms[n:]
+	}
+	return first
+}
+
+// writePacket copies ps into a pooled buffer, frames it unless the peer is
+// a stock QUIC client, and queues it, so the caller may reuse ps at once.
+func (bs *baseServer) writePacket(ps []byte, addr *Addr, timeout <-chan struct{}) (int, error) {
+	buffer := getBuffer()
+	data := (*buffer)[:0]
+	if len(ps)+headerSize > cap(data) {
+		putBuffer(buffer)
+		buffer, data = nil, make([]byte, 0, len(ps)+headerSize)
+	}
+	data = append(data, ps...)
+	if !addr.plain {
+		data = bs.framing.appendPacket(data, addr, addr.Addr)
+	}
+	if err := bs.socketFor(addr).sender.send(data, buffer, addr.Addr, timeout); err != nil {
+		return 0, err
+	}
+	return len(ps), nil
+}
@@ -0,0 +1,45 @@
+//go:build linux
+
+package kuic
+
+import (
+	"errors"
+	"golang.org/x/sys/unix"
+	"net"
+	"os"
+	"unsafe"
+)
+
+func newBatchWriter(conn *net.UDPConn) batchWriter {
+	return newPacketConn(conn)
+}
+
+func gsoSupported(conn *net.UDPConn) bool {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return false
+	}
+	var serr error
+	err = rawConn.Control(func(fd uintptr) {
+		_, serr = unix.GetsockoptInt(int(fd), unix.IPPROTO_UDP, unix.UDP_SEGMENT)
+	})
+	return err == nil && serr == nil
+}
+
+func appendUDPSegment(b []byte, size int) []byte {
+	start := len(b)
+	b = append(b, make([]byte, unix.CmsgSpace(2))...)
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&b[start]))
+	h.Level = unix.IPPROTO_UDP
+	h.Type = unix.UDP_SEGMENT
+	h.SetLen(unix.CmsgLen(2))
+	*(*uint16)(unsafe.Pointer(&b[start+unix.CmsgSpace(0)])) = uint16(size)
+	return b
+}
+
+// isGSOError reports an EIO, which is what sendmsg returns when the
+// interface cannot offload the checksums of a GSO message.
+func isGSOError(err error) bool {
+	var serr *os.SyscallError
+	return errors.As(err, &serr) && serr.Err == unix.EIO
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+
+package kuic
+
+import (
+	"net"
+)
+
+// newBatchWriter stands in for sendmmsg with loopWriter, as x/net only
+// emulates it with one sendmsg per message outside Linux anyway.
+func newBatchWriter(conn *net.UDPConn) batchWriter {
+	return &loopWriter{conn: conn}
+}
+
+func gsoSupported(conn *net.UDPConn) bool {
+	return false
+}
+
+func appendUDPSegment(b []byte, size int) []byte {
+	return b
+}
+
+func isGSOError(err error) bool {
+	return false
+}
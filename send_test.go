@@ -0,0 +1,145 @@
+package kuic
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWriteToKeepsCallerBuffer(t *testing.T) {
+	listener := listenLocal(t)
+	sink, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	backing := bytes.Repeat([]byte{0xAB}, 64)
+	if _, err := listener.baseServer.WriteTo(backing[:32], NewAddr(sink.LocalAddr(), 7)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(backing, bytes.Repeat([]byte{0xAB}, 64)) {
+		t.Fatal("WriteTo wrote past the end of the caller's slice")
+	}
+	sink.SetReadDeadline(time.Now().Add(2 * time.Second))
+	data := make([]byte, MaxPacketBufferSize)
+	n, _, err := sink.ReadFrom(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, payload, ok := parseHeader(data[:n])
+	if !ok || h.seq != 7 || !bytes.Equal(payload, backing[:32]) {
+		t.Fatalf("received %v", data[:n])
+	}
+}
+
+func TestCloseStopsSender(t *testing.T) {
+	listener, err := Listen(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := listener.baseServer.sockets[0].sender
+	listener.Close()
+	select {
+	case <-sender.closed:
+	default:
+		t.Fatal("sender still running after Close")
+	}
+	if err := sender.send(nil, getBuffer(), listener.Addr(), nil); err != net.ErrClosed {
+		t.Fatalf("expected net.ErrClosed sending after Close, got %v", err)
+	}
+}
+
+func testSendBurst(t *testing.T, gso bool) {
+	listener := listenLocal(t)
+	if gso && !listener.baseServer.sockets[0].sender.gso.Load() {
+		t.Skip("no UDP GSO")
+	}
+	listener.baseServer.sockets[0].sender.gso.Store(gso)
+	sink, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	sink.SetReadBuffer(4 << 20)
+	to := NewAddr(sink.LocalAddr(), 1)
+	const writers, packets = 4, 50
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < packets; i++ {
+				// every tenth packet is short, which ends a GSO run
+				size := 1000
+				if i%10 == 9 {
+					size = 100
+				}
+				listener.baseServer.WriteTo(bytes.Repeat([]byte{byte(w)}, size), to)
+			}
+		}(w)
+	}
+	wg.Wait()
+	received := make(map[byte]int)
+	data := make([]byte, MaxPacketBufferSize)
+	for n := 0; n < writers*packets; n++ {
+		sink.SetReadDeadline(time.Now().Add(2 * time.Second))
+		size, _, err := sink.ReadFrom(data)
+		if err != nil {
+			t.Fatalf("received %d of %d packets: %v", n, writers*packets, err)
+		}
+		_, payload, ok := parseHeader(data[:size])
+		if !ok || (len(payload) != 1000 && len(payload) != 100) || !bytes.Equal(payload, bytes.Repeat(payload[:1], len(payload))) {
+			t.Fatalf("corrupt packet of %d bytes", size)
+		}
+		received[payload[0]]++
+	}
+	for w := 0; w < writers; w++ {
+		if received[byte(w)] != packets {
+			t.Fatalf("writer %d: received %d packets, want %d", w, received[byte(w)], packets)
+		}
+	}
+}
+
+func TestSendBurst(t *testing.T) {
+	testSendBurst(t, false)
+}
+
+func TestSendBurstGSO(t *testing.T) {
+	testSendBurst(t, true)
+}
+
+func BenchmarkSend(b *testing.B) {
+	listener, err := Listen(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer listener.Close()
+	sink, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer sink.Close()
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			if _, _, err := sink.ReadFrom(buf); err != nil {
+				return
+			}
+		}
+	}()
+	to := NewAddr(sink.LocalAddr(), 1)
+	b.SetBytes(quicPacketSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		data := make([]byte, quicPacketSize, MaxPacketBufferSize)
+		for pb.Next() {
+			if _, err := listener.baseServer.WriteTo(data, to); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
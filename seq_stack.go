@@ -0,0 +1,112 @@
+package kuic
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrConnNumOver = errors.New("no free seq for a new virtual conn")
+
+// DefaultSeqQuarantine is how long a released seq waits before it is handed
+// out again, so that late packets of the closed conn find no one.
+const DefaultSeqQuarantine = 10 * time.Second
+
+// SeqStats shows how close a listener is to running out of seqs: every
+// dialed connection holds one of Limit until it is closed, and then one
+// more quarantine period.
+type SeqStats struct {
+	Limit       int
+	InUse       int
+	Quarantined int
+	// Exhausted counts the dials refused for want of a seq.
+	Exhausted uint64
+	// Stale counts the packets dropped because they were meant for an
+	// earlier generation of their seq.
+	Stale uint64
+}
+
+type seqEntry struct {
+	seq      uint16
+	released time.Time
+}
+
+// seqStack hands out seqs oldest released first; each hand-out of a seq
+// starts a new generation of it.
+type seqStack struct {
+	l          *list.List
+	gens       []byte
+	inUse      int
+	quarantine time.Duration
+	exhausted  atomic.Uint64
+	stale      atomic.Uint64
+	locker     *sync.Mutex
+}
+
+func (s *seqStack) init() {
+	num := int(MaxSeqNum)
+	s.gens = make([]byte, num)
+	for i := 0; i < num; i++ {
+		s.l.PushBack(seqEntry{seq: uint16(i)})
+	}
+}
+
+func (s *seqStack) pop() (uint16, byte, error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	if s.l.Len() > 0 {
+		ele := s.l.Front()
+		entry := ele.Value.(seqEntry)
+		if entry.released.IsZero() || time.Since(entry.released) >= s.quarantine {
+			s.l.Remove(ele)
+			s.inUse++
+			s.gens[entry.seq]++
+			if s.gens[entry.seq] == 0 {
+				s.gens[entry.seq] = 1
+			}
+			return entry.seq, s.gens[entry.seq], nil
+		}
+	}
+	s.exhausted.Add(1)
+	return 0, 0, fmt.Errorf("%w: %d of %d seqs in use, %d quarantined", ErrConnNumOver, s.inUse, MaxSeqNum, s.quarantined())
+}
+
+func (s *seqStack) push(seq uint16) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	s.inUse--
+	s.l.PushBack(seqEntry{seq: seq, released: time.Now()})
+}
+
+// quarantined counts the seqs released less than a quarantine ago, which
+// are all at the back of the list.
+func (s *seqStack) quarantined() int {
+	n := 0
+	for ele := s.l.Back(); ele != nil; ele = ele.Prev() {
+		released := ele.Value.(seqEntry).released
+		if released.IsZero() || time.Since(released) >= s.quarantine {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+func (s *seqStack) stats() SeqStats {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	return SeqStats{Limit: int(MaxSeqNum), InUse: s.inUse, Quarantined: s.quarantined(), Exhausted: s.exhausted.Load(), Stale: s.stale.Load()}
+}
+
+func newSeqStack() *seqStack {
+	seqStack := &seqStack{l: new(list.List), quarantine: DefaultSeqQuarantine, locker: new(sync.Mutex)}
+	seqStack.init()
+	return seqStack
+}
+
+func (l *Listener) SeqStats() SeqStats {
+	return l.baseServer.seqStack.stats()
+}
@@ -1,8 +1,11 @@
 package kuic
 
 import (
+	"errors"
 	"log"
+	"net"
 	"testing"
+	"time"
 )
 
 func TestSeq(t *testing.T) {
@@ -12,3 +15,55 @@ func TestSeq(t *testing.T) {
 	log.Println(seq.pop())
 	log.Println(seq.pop())
 }
+
+func TestSeqQuarantine(t *testing.T) {
+	s := newSeqStack()
+	s.quarantine = 100 * time.Millisecond
+	for i := 0; i < int(MaxSeqNum); i++ {
+		if _, gen, err := s.pop(); err != nil || gen != 1 {
+			t.Fatalf("pop %d: gen %d, %v", i, gen, err)
+		}
+	}
+	if _, _, err := s.pop(); !errors.Is(err, ErrConnNumOver) {
+		t.Fatalf("expected ErrConnNumOver, got %v", err)
+	}
+	s.push(5)
+	if _, _, err := s.pop(); !errors.Is(err, ErrConnNumOver) {
+		t.Fatalf("quarantined seq handed out: %v", err)
+	}
+	stats := s.stats()
+	if stats.InUse != int(MaxSeqNum)-1 || stats.Quarantined != 1 || stats.Exhausted != 2 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	time.Sleep(s.quarantine)
+	seq, gen, err := s.pop()
+	if err != nil || seq != 5 || gen != 2 {
+		t.Fatalf("popped %d gen %d, %v", seq, gen, err)
+	}
+}
+
+func TestStalePacket(t *testing.T) {
+	listener := listenLocal(t)
+	conn, err := listener.baseServer.GetClientConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	lAddr := conn.LocalAddr().(*Addr)
+	sender, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	sender.WriteTo(appendHeader([]byte("stale"), header{seq: lAddr.seq, gen: lAddr.gen + 1}), listener.Addr())
+	sender.WriteTo(appendHeader([]byte("fresh"), header{seq: lAddr.seq, gen: lAddr.gen}), listener.Addr())
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	data := make([]byte, MaxPacketBufferSize)
+	n, _, err := conn.ReadFrom(data)
+	if err != nil || string(data[:n]) != "fresh" {
+		t.Fatalf("read %q, %v", data[:n], err)
+	}
+	if stale := listener.SeqStats().Stale; stale != 1 {
+		t.Fatalf("stale %d, want 1", stale)
+	}
+}
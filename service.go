@@ -0,0 +1,91 @@
+package kuic
+
+import (
+	"errors"
+	"fmt"
+	"github.com/quic-go/quic-go"
+	"net"
+)
+
+// ServiceID tells apart the QUIC servers sharing a listener's socket.
+// DefaultService is the one Listen starts.
+type ServiceID uint8
+
+const (
+	DefaultService ServiceID = 0
+	maxServices              = 256
+)
+
+var ErrServiceInUse = errors.New("service already in use")
+
+// GetServiceConn returns the server conn of service id, which only one caller
+// may hold at a time. It receives from all sockets of the base server, but
+// its LocalAddr and socket buffers are those of the first.
+func (bs *baseServer) GetServiceConn(id ServiceID) (*BasicConn, error) {
+	bs.locker.Lock()
+	defer bs.locker.Unlock()
+	if bc := bs.registry.services[id].Load(); bc != nil {
+		return bc, fmt.Errorf("%w: %d", ErrServiceInUse, id)
+	}
+	bc := bs.newBasicConn(bs.sockets[0], 0, 0, id)
+	bs.registry.services[id].Store(bc)
+	return bc, nil
+}
+
+func (bs *baseServer) releaseService(id ServiceID, bc *BasicConn) {
+	bc.Close()
+	bs.registry.services[id].CompareAndSwap(bc, nil)
+}
+
+// Service is a QUIC server of its own next to the listener's, reached with
+// Listener.DialService.
+type Service struct {
+	id         ServiceID
+	conn       *BasicConn
+	listener   *quic.Listener
+	baseServer *baseServer
+}
+
+// Service starts the server for id with config; a nil config gets the same
+// defaults as Listen.
+func (l *Listener) Service(id ServiceID, config *Config) (*Service, error) {
+	conn, err := l.baseServer.GetServiceConn(id)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := quic.Listen(conn, config.ServerTLSConfig(), config.TransportConfig())
+	if err != nil {
+		l.baseServer.releaseService(id, conn)
+		return nil, err
+	}
+	return &Service{id: id, conn: conn, listener: listener, baseServer: l.baseServer}, nil
+}
+
+// GetServiceConn is GetServerConn for service id, for servers that run QUIC
+// themselves, like http3.Server.
+func (l *Listener) GetServiceConn(id ServiceID) (net.PacketConn, error) {
+	return l.baseServer.GetServiceConn(id)
+}
+
+// DialService dials the service id of the listener at addr.
+func (l *Listener) DialService(addr *net.UDPAddr, id ServiceID) (Connection, error) {
+	return l.baseServer.dialService(addr, id, l.baseServer.config)
+}
+
+func (s *Service) ID() ServiceID {
+	return s.id
+}
+
+func (s *Service) Accept() (Connection, error) {
+	conn, err := s.listener.Accept(s.baseServer.context)
+	if err != nil {
+		return nil, err
+	}
+	return createConnection(conn), nil
+}
+
+func (s *Service) Close() error {
+	err := s.listener.Close()
+	s.baseServer.releaseService(s.id, s.conn)
+	return err
+}
@@ -0,0 +1,95 @@
+package kuic
+
+import (
+	"context"
+	"errors"
+	"github.com/quic-go/quic-go"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func serveTagged(accept func() (Connection, error), tag string) {
+	for {
+		conn, err := accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			stream, err := conn.AcceptStream(context.Background())
+			if err != nil {
+				return
+			}
+			io.ReadAll(stream)
+			stream.Write([]byte(tag))
+			stream.Close()
+		}()
+	}
+}
+
+func askTag(t *testing.T, conn Connection) string {
+	stream, err := conn.OpenStreamSync(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Close()
+	data, err := io.ReadAll(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestService(t *testing.T) {
+	server := listenLocal(t)
+	go serveTagged(server.Accept, "default")
+	rpc, err := server.Service(7, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go serveTagged(rpc.Accept, "rpc")
+	if _, err := server.Service(7, nil); !errors.Is(err, ErrServiceInUse) {
+		t.Fatalf("expected ErrServiceInUse, got %v", err)
+	}
+	if _, err := server.Service(DefaultService, nil); !errors.Is(err, ErrServiceInUse) {
+		t.Fatalf("expected ErrServiceInUse for the default service, got %v", err)
+	}
+
+	client := listenLocal(t)
+	conn, err := client.DialService(server.Addr(), 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if tag := askTag(t, conn); tag != "rpc" {
+		t.Fatalf("service 7 answered %q", tag)
+	}
+	conn, err = client.Dial(server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if tag := askTag(t, conn); tag != "default" {
+		t.Fatalf("default service answered %q", tag)
+	}
+	conns := server.Conns()
+	if len(conns) != 2 || conns[0].Service != DefaultService || conns[1].Service != 7 {
+		t.Fatalf("unexpected conns %+v", conns)
+	}
+
+	rpc.Close()
+	impatient, err := ListenConfig(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, &Config{QuicConfig: &quic.Config{HandshakeIdleTimeout: 300 * time.Millisecond}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer impatient.Close()
+	if _, err := impatient.DialService(server.Addr(), 9); err == nil {
+		t.Fatal("dialed a service nobody serves")
+	}
+	rpc, err = server.Service(7, nil)
+	if err != nil {
+		t.Fatalf("service id not released: %v", err)
+	}
+	rpc.Close()
+}
@@ -0,0 +1,207 @@
+package kuic
+
+import (
+	"container/list"
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+var errNoAddrs = errors.New("no address to listen on")
+
+// maxRoutes bounds the peers a multi-socket server remembers the socket
+// of, and routeRefresh is how stale the recency of one may get.
+const (
+	maxRoutes    = 4096
+	routeRefresh = time.Second
+)
+
+// aLongTimeAgo is a read deadline that wakes a blocked reader at once.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// socket is one of the transports of a base server, together with the
+// sender that owns its write side; readerDone is closed once run returns.
+type socket struct {
+	conn       net.PacketConn
+	sender     *sender
+	readerDone chan struct{}
+	v4         bool
+}
+
+func newSocket(conn net.PacketConn) *socket {
+	s := &socket{conn: conn, sender: newSender(conn), readerDone: make(chan struct{})}
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+		s.v4 = addr.IP.To4() != nil
+	}
+	return s
+}
+
+// stop waits for the reader and the sender of s, which stops with the
+// context of the base server, and leaves conn readable for its owner again.
+func (s *socket) stop() {
+	if s.conn.SetReadDeadline(aLongTimeAgo) == nil {
+		<-s.readerDone
+		s.conn.SetReadDeadline(time.Time{})
+	}
+	<-s.sender.closed
+}
+
+// routes remembers which socket each peer was last heard on, so that the
+// answers leave through the interface the peer reached. Past maxRoutes the
+// peer heard from least recently is forgotten.
+type routes struct {
+	peers  map[netip.AddrPort]*list.Element
+	order  *list.List
+	locker *sync.RWMutex
+}
+
+// route is an element of routes.order, most recently seen first.
+type route struct {
+	key    netip.AddrPort
+	socket *socket
+	seen   time.Time
+}
+
+func newRoutes() *routes {
+	return &routes{peers: make(map[netip.AddrPort]*list.Element), order: list.New(), locker: new(sync.RWMutex)}
+}
+
+func routeKey(addr *net.UDPAddr) netip.AddrPort {
+	addrPort := addr.AddrPort()
+	return netip.AddrPortFrom(addrPort.Addr().Unmap(), addrPort.Port())
+}
+
+// see records that addr was heard on s. A peer already known on s is moved
+// to the front at most every routeRefresh, so that most packets only take
+// the read lock.
+func (r *routes) see(addr net.Addr, s *socket) {
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return
+	}
+	key := routeKey(udpAddr)
+	now := time.Now()
+	r.locker.RLock()
+	element, ok := r.peers[key]
+	fresh := ok && element.Value.(*route).socket == s && now.Sub(element.Value.(*route).seen) < routeRefresh
+	r.locker.RUnlock()
+	if fresh {
+		return
+	}
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	if element, ok := r.peers[key]; ok {
+		route := element.Value.(*route)
+		route.socket, route.seen = s, now
+		r.order.MoveToFront(element)
+		return
+	}
+	if r.order.Len() >= maxRoutes {
+		oldest := r.order.Back()
+		r.order.Remove(oldest)
+		delete(r.peers, oldest.Value.(*route).key)
+	}
+	r.peers[key] = r.order.PushFront(&route{key: key, socket: s, seen: now})
+}
+
+func (r *routes) get(addr *net.UDPAddr) *socket {
+	r.locker.RLock()
+	defer r.locker.RUnlock()
+	if element, ok := r.peers[routeKey(addr)]; ok {
+		return element.Value.(*route).socket
+	}
+	return nil
+}
+
+// socketFor picks the socket to reach addr through: the one the peer was
+// last heard on, or else the first one of its address family.
+func (bs *baseServer) socketFor(addr net.Addr) *socket {
+	if len(bs.sockets) == 1 {
+		return bs.sockets[0]
+	}
+	if a, ok := addr.(*Addr); ok {
+		addr = a.Addr
+	}
+	if relayAddr, ok := addr.(*RelayAddr); ok {
+		addr = relayAddr.Relay
+	}
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return bs.sockets[0]
+	}
+	if s := bs.routes.get(udpAddr); s != nil {
+		return s
+	}
+	v4 := udpAddr.IP.To4() != nil
+	for _, s := range bs.sockets {
+		if s.v4 == v4 {
+			return s
+		}
+	}
+	return bs.sockets[0]
+}
+
+// writeRaw writes data to addr as is, for packets that bypass the sender.
+func (bs *baseServer) writeRaw(data []byte, addr net.Addr) (int, error) {
+	return bs.socketFor(addr).conn.WriteTo(data, addr)
+}
+
+func closeAll(conns []net.PacketConn) {
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+// ListenAddrs listens on all of addrs with one listener: its conns and
+// sessions are shared by the sockets, Accept returns those of any of them
+// and Dial sends through one of the destination's address family. IPv4
+// addresses get an IPv4 socket and IPv6 ones an IPv6-only socket.
+func ListenAddrs(addrs []*net.UDPAddr, config *Config) (*Listener, error) {
+	if len(addrs) == 0 {
+		return nil, errNoAddrs
+	}
+	conns := make([]net.PacketConn, 0, len(addrs))
+	for _, addr := range addrs {
+		network := "udp"
+		if addr != nil && addr.IP != nil {
+			network = "udp6"
+			if addr.IP.To4() != nil {
+				network = "udp4"
+			}
+		}
+		udpConn, err := net.ListenUDP(network, addr)
+		if err != nil {
+			closeAll(conns)
+			return nil, err
+		}
+		conns = append(conns, udpConn)
+	}
+	return listenPackets(conns, true, config)
+}
+
+// ListenDualStack listens on port of all IPv4 and all IPv6 addresses, with
+// a socket for each family; for port zero the IPv6 socket takes the port
+// picked for the IPv4 one.
+func ListenDualStack(port int, config *Config) (*Listener, error) {
+	v4, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero, Port: port})
+	if err != nil {
+		return nil, err
+	}
+	v6, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6unspecified, Port: v4.LocalAddr().(*net.UDPAddr).Port})
+	if err != nil {
+		v4.Close()
+		return nil, err
+	}
+	return listenPackets([]net.PacketConn{v4, v6}, true, config)
+}
+
+// ListenPackets is ListenAddrs over transports of the caller's, which stay
+// open when the listener is closed.
+func ListenPackets(conns []net.PacketConn, config *Config) (*Listener, error) {
+	if len(conns) == 0 {
+		return nil, errNoAddrs
+	}
+	return listenPackets(conns, false, config)
+}
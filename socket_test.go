@@ -0,0 +1,146 @@
+package kuic
+
+import (
+	"context"
+	"github.com/chuccp/kuic/netsim"
+	"net"
+	"testing"
+	"time"
+)
+
+func listenSimAddrs(t *testing.T, network *netsim.Network, ips ...string) *Listener {
+	var conns []net.PacketConn
+	for _, ip := range ips {
+		conn, err := network.Listen(&net.UDPAddr{IP: net.ParseIP(ip)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, conn)
+	}
+	listener, err := ListenPackets(conns, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		listener.Close()
+		closeAll(conns)
+	})
+	return listener
+}
+
+func TestListenPacketsInterfaces(t *testing.T) {
+	network := netsim.New(netsim.LinkConfig{}, 1)
+	server := listenSimAddrs(t, network, "10.0.0.1", "10.0.1.1")
+	echoServer(server)
+	addrs := server.Addrs()
+	if len(addrs) != 2 {
+		t.Fatalf("listener has addresses %v", addrs)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i, addr := range addrs {
+		peer := listenSim(t, network, "10.0.2."+string(rune('1'+i)))
+		// the answer to a punch has to come from the address punched
+		if err := peer.Punch(ctx, addr); err != nil {
+			t.Fatal(err)
+		}
+		conn, err := peer.Dial(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if err := echo(conn); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestListenPacketsDualStack(t *testing.T) {
+	network := netsim.New(netsim.LinkConfig{}, 1)
+	server := listenSimAddrs(t, network, "10.0.0.1", "fd00::1")
+	peers := map[string]*Listener{"10.0.1.1": listenSim(t, network, "10.0.1.1"), "fd00::2": listenSim(t, network, "fd00::2")}
+	for ip, peer := range peers {
+		accepted := make(chan Connection, 1)
+		go func() {
+			conn, err := peer.Accept()
+			if err == nil {
+				accepted <- conn
+			}
+		}()
+		conn, err := server.Dial(peer.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		local := conn.LocalAddr().(*net.UDPAddr)
+		select {
+		case conn := <-accepted:
+			remote := conn.RemoteAddr().(*net.UDPAddr)
+			if (remote.IP.To4() != nil) != (net.ParseIP(ip).To4() != nil) {
+				t.Fatalf("dial to %s came from %s", ip, remote)
+			}
+			if !remote.IP.Equal(local.IP) {
+				t.Fatalf("dial from %s reports local address %s", remote, local)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("dial to %s not accepted", ip)
+		}
+	}
+}
+
+func TestListenDualStack(t *testing.T) {
+	listener, err := ListenDualStack(0, nil)
+	if err != nil {
+		t.Skip("no dual-stack sockets: ", err)
+	}
+	echoServer(listener)
+	port := listener.Addr().Port
+	for _, ip := range []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback} {
+		client, err := ListenAddrs([]*net.UDPAddr{{IP: ip}}, nil)
+		if err != nil {
+			t.Skip("no loopback for ", ip, ": ", err)
+		}
+		conn, err := client.Dial(&net.UDPAddr{IP: ip, Port: port})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := echo(conn); err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+		client.Close()
+	}
+	addrs := listener.Addrs()
+	listener.Close()
+	for _, addr := range addrs {
+		conn, err := net.ListenUDP("udp", addr)
+		if err != nil {
+			t.Fatalf("%v still bound after Close: %v", addr, err)
+		}
+		conn.Close()
+	}
+}
+
+func TestRoutesEvictLeastRecentlySeen(t *testing.T) {
+	routes := newRoutes()
+	a, b := &socket{}, &socket{}
+	peer := func(i int) *net.UDPAddr {
+		return &net.UDPAddr{IP: net.IPv4(10, byte(i>>16), byte(i>>8), byte(i)), Port: 1}
+	}
+	for i := 0; i < maxRoutes; i++ {
+		routes.see(peer(i), a)
+	}
+	// peer 0 is heard from again, on the other socket, and peer 1 is the
+	// least recently seen now
+	routes.see(peer(0), b)
+	routes.see(peer(maxRoutes), a)
+	if routes.get(peer(0)) != b {
+		t.Fatal("peer heard again was evicted or kept its old socket")
+	}
+	if routes.get(peer(1)) != nil || routes.get(peer(2)) != a || routes.get(peer(maxRoutes)) != a {
+		t.Fatal("not the least recently seen peer was evicted")
+	}
+	if routes.order.Len() != maxRoutes || len(routes.peers) != maxRoutes {
+		t.Fatalf("table holds %d peers", routes.order.Len())
+	}
+}
@@ -0,0 +1,53 @@
+package kuic
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestUniStream(t *testing.T) {
+	a := listenLocal(t)
+	b := listenLocal(t)
+	received := make(chan string, 1)
+	go func() {
+		conn, err := b.Accept()
+		if err != nil {
+			return
+		}
+		stream, err := conn.AcceptUniStream(context.Background())
+		if err != nil {
+			return
+		}
+		data, _ := io.ReadAll(stream)
+		received <- string(data)
+	}()
+	conn, err := a.Dial(b.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := conn.AcceptStream(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("accept was not cancelled: %v", err)
+	}
+
+	stream, err := conn.OpenUniStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Write([]byte("log line"))
+	stream.Close()
+	select {
+	case data := <-received:
+		if data != "log line" {
+			t.Fatalf("received %q", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("uni stream not received")
+	}
+}
@@ -0,0 +1,283 @@
+package kuic
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+var ErrStunTimeout = errors.New("stun: no response from server")
+
+var errStunMalformed = errors.New("stun: malformed message")
+
+const stunMagicCookie uint32 = 0x2112A442
+
+const stunHeaderSize = 20
+
+const (
+	stunBindingRequest uint16 = 0x0001
+	stunBindingSuccess uint16 = 0x0101
+	stunBindingError   uint16 = 0x0111
+)
+
+const (
+	stunAttrMappedAddress    uint16 = 0x0001
+	stunAttrErrorCode        uint16 = 0x0009
+	stunAttrXorMappedAddress uint16 = 0x0020
+)
+
+const stunRto = 500 * time.Millisecond
+
+const stunMaxTransmissions = 7
+
+type stunAttr struct {
+	typ   uint16
+	value []byte
+}
+
+type stunMessage struct {
+	typ   uint16
+	txID  [12]byte
+	attrs []stunAttr
+}
+
+func newStunMessage(typ uint16) *stunMessage {
+	msg := &stunMessage{typ: typ}
+	rand.Read(msg.txID[:])
+	return msg
+}
+
+// isStunPacket reports whether data looks like an RFC 5389 message: the two
+// leading zero bits, the magic cookie and a length that matches the datagram.
+// QUIC packets always have the fixed bit set, so they never match.
+func isStunPacket(data []byte) bool {
+	if len(data) < stunHeaderSize || data[0]&0xC0 != 0 {
+		return false
+	}
+	if binary.BigEndian.Uint32(data[4:8]) != stunMagicCookie {
+		return false
+	}
+	length := int(binary.BigEndian.Uint16(data[2:4]))
+	return length%4 == 0 && length+stunHeaderSize == len(data)
+}
+
+func parseStunMessage(data []byte) (*stunMessage, error) {
+	if !isStunPacket(data) {
+		return nil, errStunMalformed
+	}
+	msg := &stunMessage{typ: binary.BigEndian.Uint16(data[0:2])}
+	copy(msg.txID[:], data[8:20])
+	rest := data[stunHeaderSize:]
+	for len(rest) >= 4 {
+		typ := binary.BigEndian.Uint16(rest[0:2])
+		length := int(binary.BigEndian.Uint16(rest[2:4]))
+		padded := (length + 3) &^ 3
+		if len(rest) < 4+padded {
+			return nil, errStunMalformed
+		}
+		msg.attrs = append(msg.attrs, stunAttr{typ: typ, value: rest[4 : 4+length]})
+		rest = rest[4+padded:]
+	}
+	return msg, nil
+}
+
+func (m *stunMessage) addAttr(typ uint16, value []byte) {
+	m.attrs = append(m.attrs, stunAttr{typ: typ, value: value})
+}
+
+func (m *stunMessage) getAttr(typ uint16) ([]byte, bool) {
+	for _, attr := range m.attrs {
+		if attr.typ == typ {
+			return attr.value, true
+		}
+	}
+	return nil, false
+}
+
+func (m *stunMessage) encode() []byte {
+	data := make([]byte, stunHeaderSize, MaxPacketBufferSize)
+	binary.BigEndian.PutUint16(data[0:2], m.typ)
+	binary.BigEndian.PutUint32(data[4:8], stunMagicCookie)
+	copy(data[8:20], m.txID[:])
+	for _, attr := range m.attrs {
+		data = binary.BigEndian.AppendUint16(data, attr.typ)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(attr.value)))
+		data = append(data, attr.value...)
+		for len(data)%4 != 0 {
+			data = append(data, 0)
+		}
+	}
+	binary.BigEndian.PutUint16(data[2:4], uint16(len(data)-stunHeaderSize))
+	return data
+}
+
+func (m *stunMessage) isResponse() bool {
+	return m.typ&0x0100 != 0
+}
+
+func (m *stunMessage) xorKey() []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint32(key[0:4], stunMagicCookie)
+	copy(key[4:], m.txID[:])
+	return key
+}
+
+func (m *stunMessage) encodeAddr(addr *net.UDPAddr, xor bool) []byte {
+	ip := addr.IP.To4()
+	family := byte(0x01)
+	if ip == nil {
+		ip = addr.IP.To16()
+		family = 0x02
+	}
+	value := make([]byte, 4+len(ip))
+	value[1] = family
+	binary.BigEndian.PutUint16(value[2:4], uint16(addr.Port))
+	copy(value[4:], ip)
+	if xor {
+		key := m.xorKey()
+		value[2] ^= key[0]
+		value[3] ^= key[1]
+		for i := range ip {
+			value[4+i] ^= key[i]
+		}
+	}
+	return value
+}
+
+func (m *stunMessage) decodeAddr(value []byte, xor bool) (*net.UDPAddr, error) {
+	if len(value) < 4 {
+		return nil, errStunMalformed
+	}
+	size := 4
+	if value[1] == 0x02 {
+		size = 16
+	} else if value[1] != 0x01 {
+		return nil, errStunMalformed
+	}
+	if len(value) < 4+size {
+		return nil, errStunMalformed
+	}
+	port := binary.BigEndian.Uint16(value[2:4])
+	ip := make(net.IP, size)
+	copy(ip, value[4:4+size])
+	if xor {
+		key := m.xorKey()
+		port ^= binary.BigEndian.Uint16(key[0:2])
+		for i := range ip {
+			ip[i] ^= key[i]
+		}
+	}
+	return &net.UDPAddr{IP: ip, Port: int(port)}, nil
+}
+
+func (m *stunMessage) mappedAddr() (*net.UDPAddr, error) {
+	if value, ok := m.getAttr(stunAttrXorMappedAddress); ok {
+		return m.decodeAddr(value, true)
+	}
+	if value, ok := m.getAttr(stunAttrMappedAddress); ok {
+		return m.decodeAddr(value, false)
+	}
+	return nil, errors.New("stun: response carries no mapped address")
+}
+
+func (m *stunMessage) errorCode() error {
+	value, ok := m.getAttr(stunAttrErrorCode)
+	if !ok || len(value) < 4 {
+		return errors.New("stun: error response")
+	}
+	code := int(value[2]&0x07)*100 + int(value[3])
+	return fmt.Errorf("stun: error response %d %s", code, value[4:])
+}
+
+type stunResponse struct {
+	msg  *stunMessage
+	from net.Addr
+}
+
+type stunClient struct {
+	writeTo WriteToFunc
+	pending map[[12]byte]chan *stunResponse
+	locker  *sync.Mutex
+}
+
+func newStunClient(writeTo WriteToFunc) *stunClient {
+	return &stunClient{writeTo: writeTo, pending: make(map[[12]byte]chan *stunResponse), locker: new(sync.Mutex)}
+}
+
+func (c *stunClient) handlePacket(data []byte, addr net.Addr) {
+	// the attributes point into data, whose buffer is reused
+	msg, err := parseStunMessage(append([]byte(nil), data...))
+	if err != nil || !msg.isResponse() {
+		return
+	}
+	c.locker.Lock()
+	ch, ok := c.pending[msg.txID]
+	c.locker.Unlock()
+	if ok {
+		select {
+		case ch <- &stunResponse{msg: msg, from: addr}:
+		default:
+		}
+	}
+}
+
+// roundTrip sends req to server, retransmitting with a doubling RTO as
+// RFC 5389 section 7.2.1 suggests, until a response with the same
+// transaction ID arrives.
+func (c *stunClient) roundTrip(ctx context.Context, server net.Addr, req *stunMessage) (*stunResponse, error) {
+	ch := make(chan *stunResponse, 1)
+	c.locker.Lock()
+	c.pending[req.txID] = ch
+	c.locker.Unlock()
+	defer func() {
+		c.locker.Lock()
+		delete(c.pending, req.txID)
+		c.locker.Unlock()
+	}()
+	data := req.encode()
+	rto := stunRto
+	for i := 0; i < stunMaxTransmissions; i++ {
+		_, err := c.writeTo(data, server)
+		if err != nil {
+			return nil, err
+		}
+		timer := time.NewTimer(rto)
+		select {
+		case resp := <-ch:
+			timer.Stop()
+			return resp, nil
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("%w: %s: %v", ErrStunTimeout, server, ctx.Err())
+		case <-timer.C:
+		}
+		rto = rto * 2
+	}
+	return nil, fmt.Errorf("%w: %s", ErrStunTimeout, server)
+}
+
+func (c *stunClient) binding(ctx context.Context, server net.Addr) (*net.UDPAddr, error) {
+	resp, err := c.roundTrip(ctx, server, newStunMessage(stunBindingRequest))
+	if err != nil {
+		return nil, err
+	}
+	if resp.msg.typ != stunBindingSuccess {
+		return nil, resp.msg.errorCode()
+	}
+	return resp.msg.mappedAddr()
+}
+
+// DiscoverPublicAddr asks stunServer for the server-reflexive address of the
+// listener's socket, i.e. the address peers outside the NAT see us as.
+func (l *Listener) DiscoverPublicAddr(ctx context.Context, stunServer string) (*net.UDPAddr, error) {
+	server, err := net.ResolveUDPAddr("udp", stunServer)
+	if err != nil {
+		return nil, err
+	}
+	return l.baseServer.stunClient.binding(ctx, server)
+}
@@ -0,0 +1,99 @@
+package kuic
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func serveStun(t *testing.T) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	go func() {
+		data := make([]byte, MaxPacketBufferSize)
+		for {
+			n, addr, err := conn.ReadFromUDP(data)
+			if err != nil {
+				return
+			}
+			req, err := parseStunMessage(data[:n])
+			if err != nil || req.typ != stunBindingRequest {
+				continue
+			}
+			resp := &stunMessage{typ: stunBindingSuccess, txID: req.txID}
+			resp.addAttr(stunAttrXorMappedAddress, resp.encodeAddr(addr, true))
+			conn.WriteToUDP(resp.encode(), addr)
+		}
+	}()
+	return conn
+}
+
+func TestStunAddr(t *testing.T) {
+	msg := newStunMessage(stunBindingSuccess)
+	for _, addr := range []*net.UDPAddr{
+		{IP: net.IPv4(203, 0, 113, 7), Port: 40000},
+		{IP: net.ParseIP("2001:db8::1"), Port: 3478},
+	} {
+		msg.attrs = nil
+		msg.addAttr(stunAttrXorMappedAddress, msg.encodeAddr(addr, true))
+		parsed, err := parseStunMessage(msg.encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		mapped, err := parsed.mappedAddr()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mapped.String() != addr.String() {
+			t.Fatalf("decoded %s, want %s", mapped, addr)
+		}
+	}
+}
+
+func TestDiscoverPublicAddr(t *testing.T) {
+	stunServer := serveStun(t)
+	listener := listenLocal(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	addr, err := listener.DiscoverPublicAddr(ctx, stunServer.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.String() != listener.Addr().String() {
+		t.Fatalf("discovered %s, want %s", addr, listener.Addr())
+	}
+
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			conn.AcceptStream(context.Background())
+		}
+	}()
+	conn, err := listener.Dial(listener.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestDiscoverPublicAddrTimeout(t *testing.T) {
+	silent, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	listener := listenLocal(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	_, err = listener.DiscoverPublicAddr(ctx, silent.LocalAddr().String())
+	if !errors.Is(err, ErrStunTimeout) {
+		t.Fatalf("expected ErrStunTimeout, got %v", err)
+	}
+}
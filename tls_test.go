@@ -0,0 +1,185 @@
+package kuic
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/chuccp/kuic/cert"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func selfSignedCertificate(t *testing.T) *tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{SerialNumber: big.NewInt(1), NotAfter: time.Now().Add(time.Hour)}
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{certDER}, PrivateKey: key}
+}
+
+func echo(conn Connection) error {
+	stream, err := conn.OpenStreamSync(context.Background())
+	if err != nil {
+		return err
+	}
+	stream.Write([]byte("tls"))
+	stream.Close()
+	_, err = io.ReadAll(stream)
+	return err
+}
+
+func TestListenWithManager(t *testing.T) {
+	manager := cert.NewManager("http/server")
+	if err := manager.Init(); err != nil {
+		t.Fatal(err)
+	}
+	server, err := ListenWithManager(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, manager)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	go func() {
+		for {
+			conn, err := server.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				stream, err := conn.AcceptStream(context.Background())
+				if err != nil {
+					return
+				}
+				io.Copy(stream, stream)
+				stream.Close()
+			}()
+		}
+	}()
+	client := listenLocal(t)
+	certificate, err := manager.CreateClientCert("aaa")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := client.DialWithCert(server.Addr(), certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := echo(conn); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	wrongName := *certificate
+	wrongName.ServerName = "example.com"
+	if _, err := client.DialWithCert(server.Addr(), &wrongName); err == nil {
+		t.Fatal("server name was not verified")
+	}
+
+	unknownClient := *certificate
+	unknownClient.Cert = selfSignedCertificate(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	conn, err = client.DialWithCert(server.Addr(), &unknownClient)
+	if err == nil {
+		done := make(chan error, 1)
+		go func() {
+			done <- echo(conn)
+		}()
+		select {
+		case err = <-done:
+		case <-ctx.Done():
+		}
+		conn.Close()
+	}
+	if err == nil {
+		t.Fatal("client certificate was not verified")
+	}
+}
+
+func TestPeerIdentity(t *testing.T) {
+	manager := cert.NewManager("http/server")
+	if err := manager.Init(); err != nil {
+		t.Fatal(err)
+	}
+	server, err := ListenWithManager(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, manager)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	accepted := make(chan Connection, 1)
+	go func() {
+		conn, err := server.Accept()
+		if err != nil {
+			return
+		}
+		accepted <- conn
+		stream, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		io.Copy(stream, stream)
+		stream.Close()
+	}()
+	client := listenLocal(t)
+	certificate, err := manager.CreateClientCert("aaa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := client.DialWithCert(server.Addr(), certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := echo(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := conn.PeerIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.ServerName != manager.GetServerName() {
+		t.Fatalf("server name %q, want %q", identity.ServerName, manager.GetServerName())
+	}
+	if conn.RemoteAddr().String() != server.Addr().String() || conn.LocalAddr().String() != client.Addr().String() {
+		t.Fatalf("unexpected addresses %s -> %s", conn.LocalAddr(), conn.RemoteAddr())
+	}
+
+	serverConn := <-accepted
+	identity, err = serverConn.PeerIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.UserName != "aaa" || identity.ServerName != manager.GetServerName() {
+		t.Fatalf("unexpected client identity %+v", identity)
+	}
+	if serverConn.RemoteAddr().String() != client.Addr().String() {
+		t.Fatalf("remote %s, want %s", serverConn.RemoteAddr(), client.Addr())
+	}
+	if !serverConn.ConnectionState().HandshakeComplete {
+		t.Fatal("handshake not complete")
+	}
+
+	plain, err := client.Dial(listenLocal(t).Addr())
+	if err == nil {
+		defer plain.Close()
+		identity, err := plain.PeerIdentity()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if identity.UserName != "" || identity.ServerName != "" {
+			t.Fatalf("identity from a certificate without kuic fields: %+v", identity)
+		}
+	}
+}
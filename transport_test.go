@@ -0,0 +1,108 @@
+package kuic
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"github.com/chuccp/kuic/netsim"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func listenSim(t *testing.T, network *netsim.Network, ip string) *Listener {
+	return listenSimConfig(t, network, ip, nil)
+}
+
+func listenSimConfig(t *testing.T, network *netsim.Network, ip string, config *Config) *Listener {
+	conn, err := network.Listen(&net.UDPAddr{IP: net.ParseIP(ip)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := ListenPacket(conn, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		listener.Close()
+		conn.Close()
+	})
+	return listener
+}
+
+func TestListenPacket(t *testing.T) {
+	network := netsim.New(netsim.LinkConfig{Loss: 0.02, Delay: 5 * time.Millisecond, Jitter: 5 * time.Millisecond, Reorder: 0.05}, 1)
+	a := listenSim(t, network, "10.0.0.1")
+	b := listenSim(t, network, "10.0.0.2")
+	if _, ok := b.baseServer.sockets[0].sender.writer.(*loopWriter); !ok || b.baseServer.sockets[0].sender.gso.Load() {
+		t.Fatalf("unexpected writer %T for a netsim transport", b.baseServer.sockets[0].sender.writer)
+	}
+	go func() {
+		conn, err := b.Accept()
+		if err != nil {
+			return
+		}
+		stream, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		io.Copy(stream, stream)
+		stream.Close()
+	}()
+
+	conn, err := a.Dial(b.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := make([]byte, 64*1024)
+	rand.Read(payload)
+	go func() {
+		stream.Write(payload)
+		stream.Close()
+	}()
+	stream.SetReadDeadline(time.Now().Add(10 * time.Second))
+	echoed, err := io.ReadAll(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(echoed, payload) {
+		t.Fatalf("echoed %d bytes, not the %d sent", len(echoed), len(payload))
+	}
+	if stats := network.Stats(); stats.Lost == 0 {
+		t.Fatalf("the link lost nothing: %+v", stats)
+	}
+}
+
+func TestListenPacketClose(t *testing.T) {
+	network := netsim.New(netsim.LinkConfig{}, 1)
+	conn, err := network.Listen(&net.UDPAddr{IP: net.ParseIP("10.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	listener, err := ListenPacket(conn, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Close()
+	peer, err := network.Listen(&net.UDPAddr{IP: net.ParseIP("10.0.0.2")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	peer.WriteTo([]byte("after"), conn.LocalAddr())
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	data := make([]byte, 16)
+	n, _, err := conn.ReadFrom(data)
+	if err != nil || string(data[:n]) != "after" {
+		t.Fatalf("transport not readable after Close: %q %v", data[:n], err)
+	}
+}
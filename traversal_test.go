@@ -0,0 +1,196 @@
+package kuic
+
+import (
+	"context"
+	"errors"
+	"github.com/chuccp/kuic/netsim"
+	"net"
+	"testing"
+	"time"
+)
+
+// simStun runs an RFC 5780 STUN server on the public side of network.
+func simStun(t *testing.T, network *netsim.Network) *net.UDPAddr {
+	return serveStun5780On(t, func(addr *net.UDPAddr) (net.PacketConn, error) {
+		return network.Listen(addr)
+	}, net.IPv4(198, 51, 100, 1), net.IPv4(198, 51, 100, 2))
+}
+
+// behindNAT puts a listener behind a NAT of its own. quic-go tells its
+// conns apart by local address, so private addresses must not repeat.
+func behindNAT(t *testing.T, network *netsim.Network, publicIP, privateIP string, config netsim.NATConfig) *Listener {
+	nat, err := network.NewNAT(net.ParseIP(publicIP), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return listenBehind(t, nat, privateIP)
+}
+
+func listenBehind(t *testing.T, nat *netsim.NAT, privateIP string) *Listener {
+	conn, err := nat.Listen(&net.UDPAddr{IP: net.ParseIP(privateIP)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := ListenPacket(conn, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		listener.Close()
+		conn.Close()
+	})
+	return listener
+}
+
+func echoServer(listener *Listener) {
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				stream, err := conn.AcceptStream(context.Background())
+				if err != nil {
+					return
+				}
+				data := make([]byte, 16)
+				n, _ := stream.Read(data)
+				stream.Write(data[:n])
+				stream.Close()
+			}()
+		}
+	}()
+}
+
+// punchAndDial has a punch towards b's public address while b punches back
+// and dials b once the punch got through.
+func punchAndDial(ctx context.Context, a, b *Listener, publicA, publicB *net.UDPAddr) error {
+	go b.Punch(ctx, publicA)
+	conn, err := a.DialWithPunch(ctx, publicB)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return echo(conn)
+}
+
+func TestPunchThroughNAT(t *testing.T) {
+	for _, test := range []struct {
+		a, b netsim.NATType
+		ok   bool
+	}{
+		{netsim.FullCone, netsim.FullCone, true},
+		{netsim.Restricted, netsim.Restricted, true},
+		{netsim.PortRestricted, netsim.PortRestricted, true},
+		{netsim.Symmetric, netsim.FullCone, true},
+		{netsim.Symmetric, netsim.Restricted, true},
+		{netsim.Symmetric, netsim.PortRestricted, false},
+		{netsim.Symmetric, netsim.Symmetric, false},
+	} {
+		t.Run(test.a.String()+"/"+test.b.String(), func(t *testing.T) {
+			network := netsim.New(netsim.LinkConfig{Delay: time.Millisecond}, 1)
+			stun := simStun(t, network)
+			a := behindNAT(t, network, "203.0.113.1", "192.168.1.2", netsim.NATConfig{Type: test.a})
+			b := behindNAT(t, network, "203.0.113.2", "192.168.2.2", netsim.NATConfig{Type: test.b})
+			echoServer(b)
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			publicA, err := a.DiscoverPublicAddr(ctx, stun.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			publicB, err := b.DiscoverPublicAddr(ctx, stun.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = punchAndDial(ctx, a, b, publicA, publicB)
+			if test.ok && err != nil {
+				t.Fatal(err)
+			}
+			if !test.ok && !errors.Is(err, ErrPunchFailed) {
+				t.Fatalf("expected ErrPunchFailed, got %v", err)
+			}
+		})
+	}
+}
+
+func TestClassifyNetsimNAT(t *testing.T) {
+	for _, test := range []struct {
+		typ                netsim.NATType
+		mapping, filtering NATBehavior
+	}{
+		{netsim.FullCone, EndpointIndependent, EndpointIndependent},
+		{netsim.Restricted, EndpointIndependent, AddressDependent},
+		{netsim.PortRestricted, EndpointIndependent, AddressAndPortDependent},
+		{netsim.Symmetric, AddressAndPortDependent, AddressAndPortDependent},
+	} {
+		t.Run(test.typ.String(), func(t *testing.T) {
+			network := netsim.New(netsim.LinkConfig{}, 1)
+			stun := simStun(t, network)
+			listener := behindNAT(t, network, "203.0.113.1", "192.168.0.2", netsim.NATConfig{Type: test.typ})
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			info, err := listener.baseServer.stunClient.classify(ctx, listener.Addr(), []*net.UDPAddr{stun}, 100*time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.NoNAT || info.Mapping != test.mapping || info.Filtering != test.filtering {
+				t.Fatalf("classified as nat=%v %s/%s", !info.NoNAT, info.Mapping, info.Filtering)
+			}
+		})
+	}
+}
+
+func TestDialThroughHairpin(t *testing.T) {
+	for _, hairpinning := range []bool{false, true} {
+		t.Run(map[bool]string{false: "off", true: "on"}[hairpinning], func(t *testing.T) {
+			network := netsim.New(netsim.LinkConfig{}, 1)
+			stun := simStun(t, network)
+			nat, err := network.NewNAT(net.ParseIP("203.0.113.1"), netsim.NATConfig{Hairpinning: hairpinning})
+			if err != nil {
+				t.Fatal(err)
+			}
+			a := listenBehind(t, nat, "192.168.0.2")
+			b := listenBehind(t, nat, "192.168.0.3")
+			echoServer(b)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			publicB, err := b.DiscoverPublicAddr(ctx, stun.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = a.DialWithPunch(ctx, publicB)
+			if hairpinning && err != nil {
+				t.Fatal(err)
+			}
+			if !hairpinning && !errors.Is(err, ErrPunchFailed) {
+				t.Fatalf("expected ErrPunchFailed without hairpinning, got %v", err)
+			}
+		})
+	}
+}
+
+func TestNATMappingExpiry(t *testing.T) {
+	network := netsim.New(netsim.LinkConfig{}, 1)
+	stun := simStun(t, network)
+	timeout := 300 * time.Millisecond
+	listener := behindNAT(t, network, "203.0.113.1", "192.168.0.2", netsim.NATConfig{MappingTimeout: timeout})
+	listener.SetKeepaliveInterval(timeout / 3)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	first, err := listener.DiscoverPublicAddr(ctx, stun.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.AddKeepalive(stun)
+	time.Sleep(2 * timeout)
+	if kept, err := listener.DiscoverPublicAddr(ctx, stun.String()); err != nil || kept.String() != first.String() {
+		t.Fatalf("keepalive did not hold mapping %v: %v %v", first, kept, err)
+	}
+	listener.RemoveKeepalive(stun)
+	time.Sleep(2 * timeout)
+	if renewed, err := listener.DiscoverPublicAddr(ctx, stun.String()); err != nil || renewed.String() == first.String() {
+		t.Fatalf("mapping %v outlived its timeout: %v %v", first, renewed, err)
+	}
+}